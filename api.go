@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"crypto/tls"
 	"io"
 	"log"
 	"strings"
@@ -8,6 +9,17 @@ import (
 )
 
 func (n *Node) BeginLink(reader io.ReadCloser, writer io.WriteCloser, logger io.Writer, name string) {
+	n.beginLink(reader, writer, logger, name, nil)
+}
+
+// Begin a link over an established TLS connection. Once the remote server says
+// hello, its certificate is verified against the network CA and the name it
+// announces.
+func (n *Node) BeginTLSLink(conn *tls.Conn, logger io.Writer, name string) {
+	n.beginLink(conn, conn, logger, name, conn)
+}
+
+func (n *Node) beginLink(reader io.ReadCloser, writer io.WriteCloser, logger io.Writer, name string, conn *tls.Conn) *Link {
 	// Set up the link itself.
 	ch := make(chan LinkMessage)
 	n.linkReadWg.Add(1)
@@ -17,21 +29,96 @@ func (n *Node) BeginLink(reader io.ReadCloser, writer io.WriteCloser, logger io.
 			n.linkRecv <- msg
 		}
 	}()
-	link := NewLink(reader, writer, 1024000, GobServerProtocolFactory, ch, n.wg)
+	link := NewNegotiatedLink(reader, writer, 1024000, GobServerProtocolFactory, ch, n.wg)
 	link.SetName(name)
-	n.NewLinks[link] = newLink{link, logger}
+	n.NewLinks[link] = newLink{link, logger, conn, time.Now()}
+	n.scheduleLinkCheck()
 
 	// Say hello.
 	timestampMs := uint64(time.Now().UnixNano() / (1000 * 1000))
-	link.WriteMessage(SSHello{1, timestampMs, n.config.ServerName, n.config.ServerDesc, n.DefaultSubnet.Name})
+	link.WriteMessage(SSHello{
+		Protocol:      PROTOCOL_VERSION,
+		LocalTimeMs:   timestampMs,
+		Name:          n.config.ServerName,
+		Description:   n.config.ServerDesc,
+		DefaultSubnet: n.DefaultSubnet.Name,
+		MinProtocol:   n.config.MinProtocol,
+		Capabilities:  n.config.Capabilities,
+		NetName:       n.config.NetName,
+	})
+	return link
 }
 
+// Create a subnet and introduce it to the network.
+func (n *Node) CreateSubnet(name, displayName, owner string) (*Subnet, error) {
+	if _, found := n.Subnet[name]; found {
+		return nil, SubnetExistsError{}
+	}
+	subnet := NewSubnet(name)
+	subnet.DisplayName = displayName
+	subnet.Owner = owner
+	subnet.Ts = time.Now().UTC()
+	n.Subnet[name] = subnet
+
+	n.SendAllCapable(subnet.Serialize(), CAP_SUBNET)
+	return subnet, nil
+}
+
+// Delete a subnet which no longer has any clients or channels. The default
+// subnet can't be deleted.
+func (n *Node) DeleteSubnet(subnet *Subnet) error {
+	if subnet == n.DefaultSubnet {
+		return DefaultSubnetError{}
+	}
+	if !subnet.IsEmpty() {
+		return SubnetNotEmptyError{}
+	}
+	delete(n.Subnet, subnet.Name)
+
+	n.SendAllCapable(&SSSubnetEnd{subnet.Name, n.Me.Name}, CAP_SUBNET)
+	return nil
+}
+
+// Set whether clients from other subnets may join the subnet's channels.
+func (n *Node) SetForeignJoinPolicy(subnet *Subnet, policy ForeignJoinPolicy) {
+	// Every server must see this as newer than the policy it replaces, even
+	// if the clock is behind whoever set that one.
+	ts := time.Now().UTC()
+	if !ts.After(subnet.PolicyTs) {
+		ts = subnet.PolicyTs.Add(time.Nanosecond)
+	}
+	subnet.ForeignJoin = policy
+	subnet.PolicyTs = ts
+	n.SendAllCapable(&SSSubnetPolicy{subnet.Name, policy, ts}, CAP_SUBNET)
+}
+
+// Join a channel, creating it if it doesn't exist yet. The channel may be in
+// another subnet than the client's, if that subnet's policy allows it.
 func (n *Node) JoinOrCreateChannel(client *Client, subnet *Subnet, name string) (*Channel, error) {
+	return n.JoinOrCreateChannelWithKey(client, subnet, name, "")
+}
+
+// Join a channel as JoinOrCreateChannel does, giving a key for channels which
+// are +k.
+func (n *Node) JoinOrCreateChannelWithKey(client *Client, subnet *Subnet, name string, key string) (*Channel, error) {
 	lname := strings.ToLower(name)
 	channel, found := subnet.Channel[lname]
+	if subnet != client.Subnet {
+		switch subnet.ForeignJoin {
+		case FOREIGN_JOIN_CLOSED:
+			return nil, ForeignJoinDeniedError{}
+		case FOREIGN_JOIN_INVITE:
+			if !found {
+				return nil, InviteOnlyError{}
+			}
+			if _, invited := channel.Invites[client]; !invited && !channel.IsInviteExcepted(client) {
+				return nil, InviteOnlyError{}
+			}
+		}
+	}
 	if !found {
 		// Creating a new channel.
-		channel := NewChannel(n, subnet, name)
+		channel = NewChannel(n, subnet, name)
 		channel.Ts = time.Now().UTC()
 
 		// Set mode +nt.
@@ -59,8 +146,20 @@ func (n *Node) JoinOrCreateChannel(client *Client, subnet *Subnet, name string)
 			return nil, AlreadyAMemberError{}
 		}
 
-		// Here is where we would check bans, if they were implemented.
+		if channel.IsBanned(client) {
+			return nil, BannedFromChannelError{}
+		}
+		if _, invited := channel.Invites[client]; channel.Mode.InviteOnly && !invited && !channel.IsInviteExcepted(client) {
+			return nil, InviteOnlyError{}
+		}
+		if channel.Mode.Key != "" && key != channel.Mode.Key {
+			return nil, BadChannelKeyError{}
+		}
+		if channel.Mode.Limit != 0 && uint32(len(channel.Member)) >= channel.Mode.Limit {
+			return nil, ChannelFullError{}
+		}
 
+		delete(channel.Invites, client)
 		mship = &Membership{
 			Ts: time.Now().UTC(),
 		}
@@ -97,11 +196,40 @@ func (n *Node) PartChannel(channel *Channel, client *Client, reason string) {
 	})
 }
 
+// Kick a member out of a channel on behalf of a local member, who must
+// outrank them as Membership.CanKick describes.
+func (n *Node) Kick(by *Client, channel *Channel, target *Client, reason string) error {
+	mship, found := channel.LocalMember[by]
+	if !found {
+		return NotOnChannelError{}
+	}
+	targetMship, found := channel.Member[target]
+	if !found {
+		return UserNotOnChannelError{}
+	}
+	if !mship.CanKick(targetMship) {
+		return ChannelPrivilegesNeededError{}
+	}
+
+	n.Handler.OnChannelKick(channel, by, target, reason)
+	msg := &SSKick{
+		From:      by.Id(),
+		Channel:   channel.Id(),
+		Target:    target.Id(),
+		Reason:    reason,
+		ChannelTs: channel.Ts,
+		Ts:        time.Now().UTC(),
+	}
+	n.removeMember(channel, target)
+	n.sendKick(msg, nil)
+	return nil
+}
+
 func (n *Node) Quit(client *Client, reason string) {
 	if !client.IsLocal() {
 		return
 	}
-	n.processQuit(client, reason)
+	n.processQuit(client, reason, SS_KILL_REASON_QUIT)
 
 	n.SendAll(&SSKill{
 		Id:         client.Id(),
@@ -112,18 +240,193 @@ func (n *Node) Quit(client *Client, reason string) {
 	})
 }
 
-func (n *Node) ChannelMessage(client *Client, channel *Channel, message string) {
+// Kill a client on behalf of a local operator. A remote client is killed by
+// its own server, which then tells the network.
+func (n *Node) Kill(by *Client, target *Client, reason string) error {
+	if !by.IsLocal() || !by.IsOper {
+		return NoPrivilegesError{}
+	}
+	msg := &SSKill{
+		Id:         target.Id(),
+		Server:     n.Me.Name,
+		Authority:  target.IsLocal(),
+		By:         by.Id(),
+		Reason:     reason,
+		ReasonCode: SS_KILL_REASON_KILLED,
+	}
+	if target.IsLocal() {
+		n.processKill(target, msg)
+		n.SendAll(msg)
+	} else {
+		target.Server.Send(msg)
+	}
+	return nil
+}
+
+// Change the nick of a local client, keeping its channels. The client gets a
+// new timestamp, which decides any collision with a client taking the same
+// nick elsewhere at the same time. Banned members without voice or above
+// can't change their nick.
+func (n *Node) ChangeNick(client *Client, nick string) error {
+	for channel, mship := range client.Member {
+		if mship.Rank() < RANK_VOICE && channel.IsBanned(client) {
+			return BannedNickChangeError{channel}
+		}
+	}
+	oldId, oldNick, err := n.renameLocal(client, client.Subnet, nick)
+	if err != nil {
+		return err
+	}
+	n.sendRename(&SSNick{
+		Id:   oldId,
+		Nick: client.Nick,
+		Ts:   client.Ts,
+	}, CAP_NICK, oldId, client, nil)
+	n.Handler.OnNickChange(client, oldNick)
+	return nil
+}
+
+// Move a local client into another subnet under the given nick, keeping its
+// channels. Usually done on behalf of services. As with ChangeNick, the client
+// gets a new timestamp.
+func (n *Node) MoveClient(client *Client, subnet *Subnet, nick string) error {
+	oldSubnet := client.Subnet
+	oldId, oldNick, err := n.renameLocal(client, subnet, nick)
+	if err != nil {
+		return err
+	}
+	n.sendRename(&SSMove{
+		Id:     oldId,
+		Subnet: subnet.Name,
+		Nick:   client.Nick,
+		Ts:     client.Ts,
+	}, CAP_MOVE, oldId, client, nil)
+	n.Handler.OnClientMove(client, oldSubnet, oldNick)
+	return nil
+}
+
+func (n *Node) renameLocal(client *Client, subnet *Subnet, nick string) (oldId SSClientId, oldNick string, err error) {
+	existing, found := subnet.Client[strings.ToLower(nick)]
+	if found && existing != client {
+		err = NameInUseError{}
+		return
+	}
+	oldId = client.Id()
+	oldNick = client.Nick
+	n.renameClient(client, subnet, nick, time.Now().UTC())
+	return
+}
+
+// Whether a client may send to a channel. Non-members can't when it's +n, and
+// members below voice can't when it's +m or they're banned.
+func (n *Node) CanSendToChannel(client *Client, channel *Channel) error {
+	rank := RANK_NONE
+	if mship, found := channel.Member[client]; found {
+		rank = mship.Rank()
+	} else if channel.Mode.NoExternalMessages {
+		return NoExternalMessagesError{}
+	}
+	if rank >= RANK_VOICE {
+		return nil
+	}
+	if channel.Mode.Moderated {
+		return ModeratedChannelError{}
+	}
+	if channel.IsBanned(client) {
+		return BannedFromChannelError{}
+	}
+	return nil
+}
+
+// Send a message to a channel, if CanSendToChannel allows it.
+func (n *Node) ChannelMessage(client *Client, channel *Channel, message string) error {
+	if err := n.CanSendToChannel(client, channel); err != nil {
+		return err
+	}
 	n.SendAll(&SSChannelMessage{
 		From:    client.Id(),
 		To:      channel.Id(),
 		Message: message,
 	})
 	n.Handler.OnChannelMessage(client, channel, message)
+	return nil
+}
+
+// Set the topic of a channel on behalf of a local member. When the channel is
+// +t, only halfops and above may change it.
+func (n *Node) SetTopic(client *Client, channel *Channel, topic string) error {
+	mship, found := channel.LocalMember[client]
+	if !found {
+		return NotOnChannelError{}
+	}
+	if channel.Mode.TopicProtected && mship.Rank() < RANK_HALFOP {
+		return ChannelPrivilegesNeededError{}
+	}
+
+	// Every server must see this as newer than the topic it replaces, even
+	// if the clock is behind whoever set that one.
+	ts := time.Now().UTC()
+	if !ts.After(channel.TopicTs) {
+		ts = channel.TopicTs.Add(time.Nanosecond)
+	}
+	channel.Topic = topic
+	channel.TopicTs = ts
+	channel.TopicBy = client.Nick
+
+	n.SendAllCapable(&SSTopic{
+		From:    client.Id(),
+		Channel: channel.Id(),
+		Topic:   topic,
+		Ts:      channel.TopicTs,
+		By:      channel.TopicBy,
+	}, CAP_TOPIC)
+	n.Handler.OnChannelTopic(channel, client, topic)
+	return nil
+}
+
+// Invite a client to a channel on behalf of a local member. The invite is
+// delivered to the target's server, which lets it join once past +i and an
+// invite-only foreign join policy. When the channel is +i, only halfops and
+// above may invite. Fails with InviteUnsupportedError if the next hop towards
+// the target's server lacks CAP_INVITE; servers further along drop invites
+// they can't pass on.
+func (n *Node) Invite(from, to *Client, channel *Channel) error {
+	mship, found := channel.LocalMember[from]
+	if !found {
+		return NotOnChannelError{}
+	}
+	if channel.Mode.InviteOnly && mship.Rank() < RANK_HALFOP {
+		return ChannelPrivilegesNeededError{}
+	}
+	if _, found := channel.Member[to]; found {
+		return AlreadyAMemberError{}
+	}
+
+	if to.IsLocal() {
+		channel.Invites[to] = struct{}{}
+		n.Handler.OnInvite(from, to, channel)
+	} else if to.Server.Route.HasCapability(CAP_INVITE) {
+		to.Server.Route.Send(&SSInvite{from.Id(), to.Id(), channel.Id()})
+	} else {
+		return InviteUnsupportedError{}
+	}
+	return nil
 }
 
 func (n *Node) ChangeChannelMode(client *Client, channel *Channel, channelModes ChannelModeDelta, memberModes []MemberModeDelta) {
 	log.Printf("CCM")
 	channelModes, memberModes = FilterChannelModes(channel, client, channelModes, memberModes)
+
+	// List entries record who set them, and when.
+	now := time.Now().UTC()
+	lists := make([]ListModeDelta, len(channelModes.Lists))
+	for idx, delta := range channelModes.Lists {
+		delta.SetBy = client.Nick
+		delta.SetTs = now
+		lists[idx] = delta
+	}
+	channelModes.Lists = lists
+
 	n.SendAll(SerializeChannelModeChange(channel, client, channelModes, memberModes))
 	appliedDelta, appliedMembers := channel.ApplyModeDelta(channelModes, memberModes)
 	if !appliedDelta.IsEmpty() || len(appliedMembers) > 0 {
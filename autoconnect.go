@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"crypto/tls"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	DefaultConnectBackoffMin = 1 * time.Second
+	DefaultConnectBackoffMax = 5 * time.Minute
+)
+
+// A server this node links to automatically whenever it isn't already
+// reachable through another route.
+type Peer struct {
+	// Name the server announces in its hello.
+	Name string
+
+	// Address passed to Config.Dial.
+	Addr string
+
+	// When several peers are unreachable, those with a higher priority are
+	// dialed first.
+	Priority int
+}
+
+type peerState struct {
+	Peer
+
+	// Consecutive failed attempts, and the earliest time of the next one.
+	failures int
+	next     time.Time
+}
+
+type dialResult struct {
+	peer *peerState
+	conn net.Conn
+	err  error
+}
+
+// Dial peers over TCP, giving up after the handshake timeout.
+func defaultDial(config Config) func(peer Peer) (net.Conn, error) {
+	return func(peer Peer) (net.Conn, error) {
+		return net.DialTimeout("tcp", peer.Addr, config.HandshakeTimeout)
+	}
+}
+
+// Add a peer to link to, replacing any existing peer with the same name. The
+// peer is dialed as soon as it is due and not on the network. Like the rest of
+// the peer API, it must be called on the node goroutine, e.g. through Do.
+func (n *Node) AddPeer(peer Peer) {
+	state, found := n.peers[peer.Name]
+	if found {
+		state.Peer = peer
+	} else {
+		n.peers[peer.Name] = &peerState{Peer: peer}
+	}
+	n.scheduleConnect()
+}
+
+// Stop linking to a peer automatically. An existing link to it is left alone.
+// Must be called on the node goroutine.
+func (n *Node) RemovePeer(name string) {
+	delete(n.peers, name)
+	n.scheduleConnect()
+}
+
+// The peers this node links to automatically. Must be called on the node
+// goroutine.
+func (n *Node) Peers() []Peer {
+	peers := make([]Peer, 0, len(n.peers))
+	for _, state := range n.peers {
+		peers = append(peers, state.Peer)
+	}
+	return peers
+}
+
+// Whether a peer should still be dialed: it hasn't been removed, and isn't on
+// the network.
+func (n *Node) peerWanted(state *peerState) bool {
+	_, linked := n.Network[state.Name]
+	return !linked && n.peers[state.Name] == state
+}
+
+// Dial the highest priority peer which is due. Only one peer is dialed at a
+// time, since linking to any one of them usually makes the others reachable.
+func (n *Node) autoconnect(now time.Time) {
+	if n.dialing != nil {
+		return
+	}
+	var best *peerState
+	for _, state := range n.peers {
+		if !n.peerWanted(state) || state.next.After(now) {
+			continue
+		}
+		if best == nil || state.Priority > best.Priority || (state.Priority == best.Priority && state.Name < best.Name) {
+			best = state
+		}
+	}
+	if best == nil {
+		n.scheduleConnect()
+		return
+	}
+
+	log.Printf("[%s] dialing peer %s at %s", n.Me.Name, best.Name, best.Addr)
+	n.dialing = best
+	dial := n.config.Dial
+	peer := best.Peer
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		conn, err := dial(peer)
+		select {
+		case n.dialed <- dialResult{best, conn, err}:
+		case <-n.done:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+}
+
+func (n *Node) handleDialResult(result dialResult) {
+	state := result.peer
+	if result.err != nil {
+		log.Printf("[%s] failed to dial peer %s: %v", n.Me.Name, state.Name, result.err)
+		n.peerFailed(state)
+		return
+	}
+	if !n.peerWanted(state) {
+		// Removed, or linked some other way, while dialing.
+		result.conn.Close()
+		n.dialing = nil
+		n.scheduleConnect()
+		return
+	}
+	conn, _ := result.conn.(*tls.Conn)
+	n.dialingLink = n.beginLink(result.conn, result.conn, nil, "peer("+state.Name+")", conn)
+}
+
+// Called whenever a new link leaves the handshake phase, successfully or not.
+func (n *Node) handshakeDone(link *Link) {
+	if n.dialing == nil || link != n.dialingLink {
+		return
+	}
+	state := n.dialing
+	if _, linked := n.Local[link]; linked {
+		// Other peers may be reachable through this one, so nothing else is
+		// dialed until its burst is complete. The link stays recorded, as the
+		// server on it needn't have the name the peer was configured with.
+		state.failures = 0
+		return
+	}
+	n.dialingLink = nil
+	if _, linked := n.Network[state.Name]; linked {
+		// Linked by another route in the meantime.
+		state.failures = 0
+		n.dialing = nil
+		n.scheduleConnect()
+		return
+	}
+	n.peerFailed(state)
+}
+
+// Called when a server finishes bursting to this node.
+func (n *Node) peerBurstComplete(from *Server, name string) {
+	if n.dialing != nil && from.Link == n.dialingLink && from.Name == name {
+		n.dialing = nil
+		n.dialingLink = nil
+		n.scheduleConnect()
+	}
+}
+
+// Called when a directly linked server splits, so that a peer which keeps
+// splitting is retried with backoff rather than immediately.
+func (n *Node) peerSplit(link *Link, name string) {
+	state, found := n.peers[name]
+	if found {
+		state.failures++
+		state.next = time.Now().Add(n.connectBackoff(state.failures))
+	}
+	if n.dialing != nil && link == n.dialingLink {
+		n.dialing = nil
+		n.dialingLink = nil
+	}
+}
+
+func (n *Node) peerFailed(state *peerState) {
+	state.failures++
+	state.next = time.Now().Add(n.connectBackoff(state.failures))
+	log.Printf("[%s] retrying peer %s after %v", n.Me.Name, state.Name, state.next.Sub(time.Now()))
+	n.dialing = nil
+	n.dialingLink = nil
+	n.scheduleConnect()
+}
+
+// The delay before the next attempt after the given number of consecutive
+// failures: exponential from the minimum backoff up to the maximum, with
+// jitter so that servers split at the same moment don't redial in lockstep.
+func (n *Node) connectBackoff(failures int) time.Duration {
+	delay := n.config.ConnectBackoffMin
+	for i := 1; i < failures && delay < n.config.ConnectBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > n.config.ConnectBackoffMax {
+		delay = n.config.ConnectBackoffMax
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Arm the connect timer for the earliest peer due to be dialed.
+func (n *Node) scheduleConnect() {
+	if n.dialing != nil {
+		return
+	}
+	wanted := false
+	var next time.Time
+	for _, state := range n.peers {
+		if !n.peerWanted(state) {
+			continue
+		}
+		if !wanted || state.next.Before(next) {
+			next = state.next
+		}
+		wanted = true
+	}
+	n.connectTimer.Stop()
+	if wanted {
+		n.connectTimer.Reset(time.Until(next))
+	}
+}
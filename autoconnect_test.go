@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Routes dials from test nodes to other test nodes over in-memory pipes, and
+// records every dial made.
+type testSwitchboard struct {
+	mutex sync.Mutex
+	nodes map[string]*Node
+	dials []string
+	fail  bool
+}
+
+func newTestSwitchboard() *testSwitchboard {
+	return &testSwitchboard{nodes: make(map[string]*Node)}
+}
+
+func (sb *testSwitchboard) NewNode(name string, wg *sync.WaitGroup) *Node {
+	config := testConfig(name)
+	config.ConnectBackoffMin = 10 * time.Millisecond
+	config.ConnectBackoffMax = 40 * time.Millisecond
+	config.Dial = sb.Dial
+	node := NewNode(config, nil, wg)
+	sb.mutex.Lock()
+	sb.nodes[name] = node
+	sb.mutex.Unlock()
+	return node
+}
+
+func (sb *testSwitchboard) Dial(peer Peer) (net.Conn, error) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.dials = append(sb.dials, peer.Name)
+	node, found := sb.nodes[peer.Addr]
+	if sb.fail || !found {
+		return nil, errors.New("connection refused")
+	}
+	local, remote := net.Pipe()
+	go node.Do(func() {
+		node.BeginLink(remote, remote, nil, "inbound")
+	})
+	return local, nil
+}
+
+func (sb *testSwitchboard) Dials() []string {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	return append([]string{}, sb.dials...)
+}
+
+func (sb *testSwitchboard) SetFail(fail bool) {
+	sb.mutex.Lock()
+	sb.fail = fail
+	sb.mutex.Unlock()
+}
+
+func TestAutoconnect_Links(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	sb := newTestSwitchboard()
+	a := sb.NewNode("hub.a", wg)
+	b := sb.NewNode("hub.b", wg)
+
+	a.Do(func() {
+		a.AddPeer(Peer{Name: "hub.b", Addr: "hub.b"})
+	})
+	waitForNode(t, a, "link to hub.b", func() bool {
+		_, found := a.Me.Links["hub.b"]
+		return found
+	})
+	if dials := sb.Dials(); len(dials) != 1 {
+		t.Errorf("Expected a single dial, got %v", dials)
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
+
+func TestAutoconnect_Backoff(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	sb := newTestSwitchboard()
+	a := sb.NewNode("hub.a", wg)
+
+	// Nothing answers at this address.
+	a.Do(func() {
+		a.AddPeer(Peer{Name: "hub.b", Addr: "nowhere"})
+	})
+	time.Sleep(200 * time.Millisecond)
+	dials := len(sb.Dials())
+
+	// With delays between 5ms and 40ms, that's at least 5 attempts but far
+	// fewer than if the peer were redialed immediately.
+	if dials < 5 || dials > 40 {
+		t.Errorf("Expected backoff between attempts, got %d dials in 200ms", dials)
+	}
+
+	a.Do(func() {
+		a.RemovePeer("hub.b")
+	})
+	dials = len(sb.Dials())
+	time.Sleep(100 * time.Millisecond)
+	if after := len(sb.Dials()); after > dials+1 {
+		t.Errorf("Expected dialing to stop after removing the peer, got %d more dials", after-dials)
+	}
+
+	a.Shutdown()
+	wg.Wait()
+}
+
+func TestAutoconnect_RedialAfterSplit(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	sb := newTestSwitchboard()
+	a := sb.NewNode("hub.a", wg)
+	b := sb.NewNode("hub.b", wg)
+
+	a.Do(func() {
+		a.AddPeer(Peer{Name: "hub.b", Addr: "hub.b"})
+	})
+	var first *Link
+	waitForNode(t, a, "link to hub.b", func() bool {
+		server, found := a.Me.Links["hub.b"]
+		if found {
+			first = server.Link
+		}
+		return found
+	})
+	waitForNode(t, b, "link to hub.a", func() bool {
+		_, found := b.Me.Links["hub.a"]
+		return found
+	})
+
+	// hub.b drops the link. The redial may follow too quickly to see hub.a
+	// without a link, so wait for a new link instead.
+	b.Do(func() {
+		for link, _ := range b.Local {
+			b.split(link, PingTimeoutError{"hub.a", 0})
+		}
+	})
+	waitForNode(t, a, "relink to hub.b", func() bool {
+		server, found := a.Me.Links["hub.b"]
+		return found && server.Link != first
+	})
+	if dials := sb.Dials(); len(dials) != 2 {
+		t.Errorf("Expected two dials, got %v", dials)
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
+
+// The highest priority peer is dialed first, and once the other peer is
+// reachable through it, the other peer is never dialed.
+func TestAutoconnect_PriorityAndOtherRoute(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	sb := newTestSwitchboard()
+	a := sb.NewNode("hub.a", wg)
+	b := sb.NewNode("hub.b", wg)
+	c := sb.NewNode("hub.c", wg)
+
+	b.Do(func() {
+		b.AddPeer(Peer{Name: "hub.c", Addr: "hub.c"})
+	})
+	waitForNode(t, b, "link to hub.c", func() bool {
+		_, found := b.Me.Links["hub.c"]
+		return found
+	})
+
+	a.Do(func() {
+		a.AddPeer(Peer{Name: "hub.b", Addr: "hub.b", Priority: 1})
+		a.AddPeer(Peer{Name: "hub.c", Addr: "hub.c", Priority: 2})
+	})
+	waitForNode(t, a, "hub.b via hub.c", func() bool {
+		_, found := a.Network["hub.b"]
+		return found
+	})
+	time.Sleep(50 * time.Millisecond)
+	dials := sb.Dials()
+	if len(dials) != 2 || dials[1] != "hub.c" {
+		t.Errorf("Expected hub.a to dial only hub.c, got %v", dials)
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	c.Shutdown()
+	wg.Wait()
+}
+
+// A peer which announces a different name than it was configured with
+// doesn't stop other peers from being dialed.
+func TestAutoconnect_NameMismatch(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	sb := newTestSwitchboard()
+	a := sb.NewNode("hub.a", wg)
+	b := sb.NewNode("hub.b", wg)
+	c := sb.NewNode("hub.c", wg)
+
+	a.Do(func() {
+		a.AddPeer(Peer{Name: "hub.x", Addr: "hub.b", Priority: 2})
+		a.AddPeer(Peer{Name: "hub.c", Addr: "hub.c", Priority: 1})
+	})
+	waitForNode(t, a, "link to hub.c", func() bool {
+		_, found := a.Me.Links["hub.c"]
+		return found
+	})
+	a.Do(func() {
+		if _, found := a.Me.Links["hub.b"]; !found {
+			t.Errorf("Expected link to hub.b")
+		}
+	})
+
+	a.Shutdown()
+	b.Shutdown()
+	c.Shutdown()
+	wg.Wait()
+}
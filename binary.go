@@ -0,0 +1,902 @@
+package lib
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// The binary codec encodes each frame's payload using the protocol buffers
+// wire format, so that tools outside of Go can read and write the s2s protocol
+// from the schema in s2s.proto. Every field is identified by its number
+// rather than its position, fields left at their zero value are omitted, and
+// fields with an unknown number are skipped.
+//
+// Field numbers are sent on the wire - do not renumber or reuse them. New
+// fields must take new numbers, and the schema file must be kept in sync.
+var BinaryServerProtocolFactory ServerProtocolFactory = &framedServerProtocolFactory{binaryFrameCodec{}, DefaultMaxFrameSize}
+
+// Wire types of the protocol buffers encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// A message which can be encoded with the binary codec.
+type binaryEncodable interface {
+	encodeBinary(e *binaryEncoder)
+}
+
+// A message which can be decoded with the binary codec, one field at a time.
+type binaryDecodable interface {
+	decodeBinaryField(d *binaryDecoder)
+}
+
+type binaryFrameCodec struct{}
+
+func (_ binaryFrameCodec) Encode(msg SSMessage) ([]byte, error) {
+	bm, ok := msg.(binaryEncodable)
+	if !ok {
+		return nil, BinaryEncodingError{msg.messageType(), "message has no binary encoding"}
+	}
+	e := &binaryEncoder{}
+	bm.encodeBinary(e)
+	return e.buf, nil
+}
+
+func (_ binaryFrameCodec) Decode(payload []byte, msg SSMessage) error {
+	bm, ok := msg.(binaryDecodable)
+	if !ok {
+		return BinaryEncodingError{msg.messageType(), "message has no binary encoding"}
+	}
+	return decodeBinary(payload, bm)
+}
+
+type binaryEncoder struct {
+	buf []byte
+}
+
+func (e *binaryEncoder) key(field uint32, wireType uint64) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|wireType)
+}
+
+func (e *binaryEncoder) Uint(field uint32, value uint64) {
+	if value == 0 {
+		return
+	}
+	e.key(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, value)
+}
+
+func (e *binaryEncoder) Int(field uint32, value int64) {
+	e.Uint(field, uint64(value))
+}
+
+func (e *binaryEncoder) Bool(field uint32, value bool) {
+	if value {
+		e.Uint(field, 1)
+	}
+}
+
+func (e *binaryEncoder) String(field uint32, value string) {
+	if value == "" {
+		return
+	}
+	e.key(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(value)))
+	e.buf = append(e.buf, value...)
+}
+
+func (e *binaryEncoder) Strings(field uint32, values []string) {
+	for _, value := range values {
+		e.key(field, wireBytes)
+		e.buf = binary.AppendUvarint(e.buf, uint64(len(value)))
+		e.buf = append(e.buf, value...)
+	}
+}
+
+// Times are sent as nanoseconds since the Unix epoch. The zero time is
+// omitted.
+func (e *binaryEncoder) Time(field uint32, value time.Time) {
+	if value.IsZero() {
+		return
+	}
+	e.Int(field, value.UnixNano())
+}
+
+// Embedded messages are always sent, even when empty.
+func (e *binaryEncoder) Message(field uint32, value binaryEncodable) {
+	inner := &binaryEncoder{}
+	value.encodeBinary(inner)
+	e.key(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(inner.buf)))
+	e.buf = append(e.buf, inner.buf...)
+}
+
+type binaryDecoder struct {
+	buf []byte
+	err error
+
+	// The field most recently read by Next.
+	Field    uint32
+	wireType uint64
+	varint   uint64
+	bytes    []byte
+}
+
+// Decode a payload into msg.
+func decodeBinary(payload []byte, msg binaryDecodable) error {
+	d := &binaryDecoder{buf: payload}
+	for d.Next() {
+		msg.decodeBinaryField(d)
+	}
+	return d.err
+}
+
+func (d *binaryDecoder) fail(reason string) {
+	if d.err == nil {
+		d.err = BinaryEncodingError{Reason: reason}
+	}
+	d.buf = nil
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	value, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("truncated varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return value
+}
+
+func (d *binaryDecoder) take(size uint64) []byte {
+	if size > uint64(len(d.buf)) {
+		d.fail("truncated field")
+		return nil
+	}
+	value := d.buf[:size]
+	d.buf = d.buf[size:]
+	return value
+}
+
+// Read the next field, returning false at the end of the payload or on error.
+func (d *binaryDecoder) Next() bool {
+	if d.err != nil || len(d.buf) == 0 {
+		return false
+	}
+	key := d.uvarint()
+	d.Field = uint32(key >> 3)
+	d.wireType = key & 7
+	switch d.wireType {
+	case wireVarint:
+		d.varint = d.uvarint()
+	case wireFixed64:
+		d.take(8)
+	case wireBytes:
+		d.bytes = d.take(d.uvarint())
+	case wireFixed32:
+		d.take(4)
+	default:
+		d.fail("unknown wire type")
+	}
+	return d.err == nil
+}
+
+func (d *binaryDecoder) expect(wireType uint64) bool {
+	if d.wireType != wireType {
+		d.fail("unexpected wire type")
+		return false
+	}
+	return true
+}
+
+func (d *binaryDecoder) Uint() uint64 {
+	if !d.expect(wireVarint) {
+		return 0
+	}
+	return d.varint
+}
+
+func (d *binaryDecoder) Uint32() uint32 {
+	return uint32(d.Uint())
+}
+
+func (d *binaryDecoder) Int() int64 {
+	return int64(d.Uint())
+}
+
+func (d *binaryDecoder) Bool() bool {
+	return d.Uint() != 0
+}
+
+func (d *binaryDecoder) String() string {
+	if !d.expect(wireBytes) {
+		return ""
+	}
+	return string(d.bytes)
+}
+
+func (d *binaryDecoder) Time() time.Time {
+	return time.Unix(0, d.Int()).UTC()
+}
+
+func (d *binaryDecoder) Message(msg binaryDecodable) {
+	if !d.expect(wireBytes) {
+		return
+	}
+	err := decodeBinary(d.bytes, msg)
+	if err != nil && d.err == nil {
+		d.err = err
+		d.buf = nil
+	}
+}
+
+// Schema for each message. Field numbers must match s2s.proto.
+
+func (msg SSHello) encodeBinary(e *binaryEncoder) {
+	e.Uint(1, uint64(msg.Protocol))
+	e.Uint(2, msg.LocalTimeMs)
+	e.String(3, msg.Name)
+	e.String(4, msg.Description)
+	e.String(5, msg.DefaultSubnet)
+	e.Uint(6, uint64(msg.MinProtocol))
+	e.Strings(7, msg.Capabilities)
+	e.String(8, msg.NetName)
+}
+
+func (msg *SSHello) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Protocol = d.Uint32()
+	case 2:
+		msg.LocalTimeMs = d.Uint()
+	case 3:
+		msg.Name = d.String()
+	case 4:
+		msg.Description = d.String()
+	case 5:
+		msg.DefaultSubnet = d.String()
+	case 6:
+		msg.MinProtocol = d.Uint32()
+	case 7:
+		msg.Capabilities = append(msg.Capabilities, d.String())
+	case 8:
+		msg.NetName = d.String()
+	}
+}
+
+func (msg SSBurstComplete) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Server)
+}
+
+func (msg *SSBurstComplete) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Server = d.String()
+	}
+}
+
+func (msg SSSync) encodeBinary(e *binaryEncoder) {
+	e.Uint(1, uint64(msg.Sequence))
+	e.Bool(2, msg.Reply)
+	e.String(3, msg.Origin)
+	e.String(4, msg.ReplyFrom)
+}
+
+func (msg *SSSync) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Sequence = d.Uint32()
+	case 2:
+		msg.Reply = d.Bool()
+	case 3:
+		msg.Origin = d.String()
+	case 4:
+		msg.ReplyFrom = d.String()
+	}
+}
+
+func (msg SSClient) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Subnet)
+	e.String(2, msg.Server)
+	e.String(3, msg.Nick)
+	e.String(4, msg.Ident)
+	e.String(5, msg.Vident)
+	e.String(6, msg.Host)
+	e.String(7, msg.Vhost)
+	e.String(8, msg.Ip)
+	e.String(9, msg.Vip)
+	e.String(10, msg.Gecos)
+	e.Time(11, msg.Ts)
+}
+
+func (msg *SSClient) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Subnet = d.String()
+	case 2:
+		msg.Server = d.String()
+	case 3:
+		msg.Nick = d.String()
+	case 4:
+		msg.Ident = d.String()
+	case 5:
+		msg.Vident = d.String()
+	case 6:
+		msg.Host = d.String()
+	case 7:
+		msg.Vhost = d.String()
+	case 8:
+		msg.Ip = d.String()
+	case 9:
+		msg.Vip = d.String()
+	case 10:
+		msg.Gecos = d.String()
+	case 11:
+		msg.Ts = d.Time()
+	}
+}
+
+func (msg SSServer) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Name)
+	e.String(2, msg.Desc)
+	e.String(3, msg.Via)
+}
+
+func (msg *SSServer) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Name = d.String()
+	case 2:
+		msg.Desc = d.String()
+	case 3:
+		msg.Via = d.String()
+	}
+}
+
+func (msg SSKill) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.Id)
+	e.String(2, msg.Server)
+	e.Bool(3, msg.Authority)
+	e.Message(4, msg.By)
+	e.String(5, msg.Reason)
+	e.Uint(6, uint64(msg.ReasonCode))
+}
+
+func (msg *SSKill) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.Id)
+	case 2:
+		msg.Server = d.String()
+	case 3:
+		msg.Authority = d.Bool()
+	case 4:
+		d.Message(&msg.By)
+	case 5:
+		msg.Reason = d.String()
+	case 6:
+		msg.ReasonCode = SSKillReason(d.Uint())
+	}
+}
+
+func (msg SSSplit) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Server)
+	e.String(2, msg.Reason)
+}
+
+func (msg *SSSplit) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Server = d.String()
+	case 2:
+		msg.Reason = d.String()
+	}
+}
+
+func (msg SSChannel) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Name)
+	e.String(2, msg.Subnet)
+	e.Time(3, msg.Ts)
+	for _, member := range msg.Members {
+		if member != nil {
+			e.Message(4, member)
+		}
+	}
+	e.Bool(5, msg.TopicProtected)
+	e.Bool(6, msg.NoExternalMessages)
+	e.Bool(7, msg.Moderated)
+	e.Bool(8, msg.Secret)
+	e.Uint(9, uint64(msg.Limit))
+	e.String(10, msg.Key)
+	e.String(11, msg.Topic)
+	e.Time(12, msg.TopicTs)
+	e.String(13, msg.TopicBy)
+	for _, entry := range msg.Lists {
+		e.Message(14, entry)
+	}
+	e.Bool(15, msg.InviteOnly)
+}
+
+func (msg *SSChannel) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Name = d.String()
+	case 2:
+		msg.Subnet = d.String()
+	case 3:
+		msg.Ts = d.Time()
+	case 4:
+		member := &SSMembership{}
+		d.Message(member)
+		msg.Members = append(msg.Members, member)
+	case 5:
+		msg.TopicProtected = d.Bool()
+	case 6:
+		msg.NoExternalMessages = d.Bool()
+	case 7:
+		msg.Moderated = d.Bool()
+	case 8:
+		msg.Secret = d.Bool()
+	case 9:
+		msg.Limit = d.Uint32()
+	case 10:
+		msg.Key = d.String()
+	case 11:
+		msg.Topic = d.String()
+	case 12:
+		msg.TopicTs = d.Time()
+	case 13:
+		msg.TopicBy = d.String()
+	case 14:
+		var entry SSListEntry
+		d.Message(&entry)
+		msg.Lists = append(msg.Lists, entry)
+	case 15:
+		msg.InviteOnly = d.Bool()
+	}
+}
+
+func (msg SSMembership) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.Client)
+	e.Message(2, msg.Channel)
+	e.Time(3, msg.Ts)
+	e.Bool(4, msg.IsOwner)
+	e.Bool(5, msg.IsAdmin)
+	e.Bool(6, msg.IsOp)
+	e.Bool(7, msg.IsHalfop)
+	e.Bool(8, msg.IsVoice)
+}
+
+func (msg *SSMembership) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.Client)
+	case 2:
+		d.Message(&msg.Channel)
+	case 3:
+		msg.Ts = d.Time()
+	case 4:
+		msg.IsOwner = d.Bool()
+	case 5:
+		msg.IsAdmin = d.Bool()
+	case 6:
+		msg.IsOp = d.Bool()
+	case 7:
+		msg.IsHalfop = d.Bool()
+	case 8:
+		msg.IsVoice = d.Bool()
+	}
+}
+
+func (msg SSPrivateMessage) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.From)
+	e.Message(2, msg.To)
+	e.String(3, msg.Message)
+}
+
+func (msg *SSPrivateMessage) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.From)
+	case 2:
+		d.Message(&msg.To)
+	case 3:
+		msg.Message = d.String()
+	}
+}
+
+func (msg SSChannelMessage) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.From)
+	e.Message(2, msg.To)
+	e.String(3, msg.Message)
+}
+
+func (msg *SSChannelMessage) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.From)
+	case 2:
+		d.Message(&msg.To)
+	case 3:
+		msg.Message = d.String()
+	}
+}
+
+func (msg SSMembershipEnd) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.Channel)
+	e.Message(2, msg.Client)
+	e.String(3, msg.Reason)
+}
+
+func (msg *SSMembershipEnd) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.Channel)
+	case 2:
+		d.Message(&msg.Client)
+	case 3:
+		msg.Reason = d.String()
+	}
+}
+
+func (delta SSMemberModeDelta) encodeBinary(e *binaryEncoder) {
+	e.Message(1, delta.Client)
+	e.Uint(2, uint64(delta.IsOwner))
+	e.Uint(3, uint64(delta.IsAdmin))
+	e.Uint(4, uint64(delta.IsOp))
+	e.Uint(5, uint64(delta.IsHalfop))
+	e.Uint(6, uint64(delta.IsVoice))
+}
+
+func (delta *SSMemberModeDelta) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&delta.Client)
+	case 2:
+		delta.IsOwner = SSModeDelta(d.Uint())
+	case 3:
+		delta.IsAdmin = SSModeDelta(d.Uint())
+	case 4:
+		delta.IsOp = SSModeDelta(d.Uint())
+	case 5:
+		delta.IsHalfop = SSModeDelta(d.Uint())
+	case 6:
+		delta.IsVoice = SSModeDelta(d.Uint())
+	}
+}
+
+func (delta SSChannelModeDelta) encodeBinary(e *binaryEncoder) {
+	e.Uint(1, uint64(delta.TopicProtected))
+	e.Uint(2, uint64(delta.NoExternalMessages))
+	e.Uint(3, uint64(delta.Moderated))
+	e.Uint(4, uint64(delta.Secret))
+	e.Uint(5, uint64(delta.Limit))
+	e.Uint(6, uint64(delta.Key))
+	e.Uint(7, uint64(delta.LimitValue))
+	e.String(8, delta.KeyValue)
+	for _, list := range delta.Lists {
+		e.Message(9, list)
+	}
+	e.Uint(10, uint64(delta.InviteOnly))
+}
+
+func (delta *SSChannelModeDelta) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		delta.TopicProtected = SSModeDelta(d.Uint())
+	case 2:
+		delta.NoExternalMessages = SSModeDelta(d.Uint())
+	case 3:
+		delta.Moderated = SSModeDelta(d.Uint())
+	case 4:
+		delta.Secret = SSModeDelta(d.Uint())
+	case 5:
+		delta.Limit = SSModeDelta(d.Uint())
+	case 6:
+		delta.Key = SSModeDelta(d.Uint())
+	case 7:
+		delta.LimitValue = d.Uint32()
+	case 8:
+		delta.KeyValue = d.String()
+	case 9:
+		var list SSListModeDelta
+		d.Message(&list)
+		delta.Lists = append(delta.Lists, list)
+	case 10:
+		delta.InviteOnly = SSModeDelta(d.Uint())
+	}
+}
+
+func (entry SSListEntry) encodeBinary(e *binaryEncoder) {
+	e.Uint(1, uint64(entry.List))
+	e.String(2, entry.Mask)
+	e.String(3, entry.SetBy)
+	e.Time(4, entry.SetTs)
+}
+
+func (entry *SSListEntry) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		entry.List = ListMode(d.Uint())
+	case 2:
+		entry.Mask = d.String()
+	case 3:
+		entry.SetBy = d.String()
+	case 4:
+		entry.SetTs = d.Time()
+	}
+}
+
+func (delta SSListModeDelta) encodeBinary(e *binaryEncoder) {
+	e.Uint(1, uint64(delta.List))
+	e.Uint(2, uint64(delta.Change))
+	e.String(3, delta.Mask)
+	e.String(4, delta.SetBy)
+	e.Time(5, delta.SetTs)
+}
+
+func (delta *SSListModeDelta) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		delta.List = ListMode(d.Uint())
+	case 2:
+		delta.Change = SSModeDelta(d.Uint())
+	case 3:
+		delta.Mask = d.String()
+	case 4:
+		delta.SetBy = d.String()
+	case 5:
+		delta.SetTs = d.Time()
+	}
+}
+
+func (msg SSChannelMode) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.From)
+	e.Message(2, msg.Channel)
+	e.Message(3, msg.Mode)
+	for _, delta := range msg.MemberMode {
+		e.Message(4, delta)
+	}
+}
+
+func (msg *SSChannelMode) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.From)
+	case 2:
+		d.Message(&msg.Channel)
+	case 3:
+		d.Message(&msg.Mode)
+	case 4:
+		var delta SSMemberModeDelta
+		d.Message(&delta)
+		msg.MemberMode = append(msg.MemberMode, delta)
+	}
+}
+
+func (msg SSPing) encodeBinary(e *binaryEncoder) {
+	e.Uint(1, msg.Cookie)
+}
+
+func (msg *SSPing) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Cookie = d.Uint()
+	}
+}
+
+func (msg SSPong) encodeBinary(e *binaryEncoder) {
+	e.Uint(1, msg.Cookie)
+}
+
+func (msg *SSPong) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Cookie = d.Uint()
+	}
+}
+
+func (msg SSError) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Reason)
+}
+
+func (msg *SSError) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Reason = d.String()
+	}
+}
+
+func (msg SSTopic) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.From)
+	e.Message(2, msg.Channel)
+	e.String(3, msg.Topic)
+	e.Time(4, msg.Ts)
+	e.String(5, msg.By)
+}
+
+func (msg *SSTopic) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.From)
+	case 2:
+		d.Message(&msg.Channel)
+	case 3:
+		msg.Topic = d.String()
+	case 4:
+		msg.Ts = d.Time()
+	case 5:
+		msg.By = d.String()
+	}
+}
+
+func (msg SSNick) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.Id)
+	e.String(2, msg.Nick)
+	e.Time(3, msg.Ts)
+}
+
+func (msg *SSNick) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.Id)
+	case 2:
+		msg.Nick = d.String()
+	case 3:
+		msg.Ts = d.Time()
+	}
+}
+
+func (msg SSMove) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.Id)
+	e.String(2, msg.Subnet)
+	e.String(3, msg.Nick)
+	e.Time(4, msg.Ts)
+}
+
+func (msg *SSMove) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.Id)
+	case 2:
+		msg.Subnet = d.String()
+	case 3:
+		msg.Nick = d.String()
+	case 4:
+		msg.Ts = d.Time()
+	}
+}
+
+func (msg SSSubnet) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Name)
+	e.String(2, msg.DisplayName)
+	e.String(3, msg.Owner)
+	e.Time(4, msg.Ts)
+	e.Uint(5, uint64(msg.ForeignJoin))
+	e.Time(6, msg.PolicyTs)
+}
+
+func (msg *SSSubnet) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Name = d.String()
+	case 2:
+		msg.DisplayName = d.String()
+	case 3:
+		msg.Owner = d.String()
+	case 4:
+		msg.Ts = d.Time()
+	case 5:
+		msg.ForeignJoin = ForeignJoinPolicy(d.Uint())
+	case 6:
+		msg.PolicyTs = d.Time()
+	}
+}
+
+func (msg SSSubnetEnd) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Name)
+	e.String(2, msg.Server)
+}
+
+func (msg *SSSubnetEnd) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Name = d.String()
+	case 2:
+		msg.Server = d.String()
+	}
+}
+
+func (msg SSSubnetPolicy) encodeBinary(e *binaryEncoder) {
+	e.String(1, msg.Name)
+	e.Uint(2, uint64(msg.ForeignJoin))
+	e.Time(3, msg.Ts)
+}
+
+func (msg *SSSubnetPolicy) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		msg.Name = d.String()
+	case 2:
+		msg.ForeignJoin = ForeignJoinPolicy(d.Uint())
+	case 3:
+		msg.Ts = d.Time()
+	}
+}
+
+func (msg SSInvite) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.From)
+	e.Message(2, msg.To)
+	e.Message(3, msg.Channel)
+}
+
+func (msg *SSInvite) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.From)
+	case 2:
+		d.Message(&msg.To)
+	case 3:
+		d.Message(&msg.Channel)
+	}
+}
+
+func (msg SSKick) encodeBinary(e *binaryEncoder) {
+	e.Message(1, msg.From)
+	e.Message(2, msg.Channel)
+	e.Message(3, msg.Target)
+	e.String(4, msg.Reason)
+	e.Time(5, msg.ChannelTs)
+	e.Time(6, msg.Ts)
+}
+
+func (msg *SSKick) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		d.Message(&msg.From)
+	case 2:
+		d.Message(&msg.Channel)
+	case 3:
+		d.Message(&msg.Target)
+	case 4:
+		msg.Reason = d.String()
+	case 5:
+		msg.ChannelTs = d.Time()
+	case 6:
+		msg.Ts = d.Time()
+	}
+}
+
+func (id SSClientId) encodeBinary(e *binaryEncoder) {
+	e.String(1, id.Server)
+	e.String(2, id.Subnet)
+	e.String(3, id.Nick)
+}
+
+func (id *SSClientId) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		id.Server = d.String()
+	case 2:
+		id.Subnet = d.String()
+	case 3:
+		id.Nick = d.String()
+	}
+}
+
+func (id SSChannelId) encodeBinary(e *binaryEncoder) {
+	e.String(1, id.Subnet)
+	e.String(2, id.Name)
+}
+
+func (id *SSChannelId) decodeBinaryField(d *binaryDecoder) {
+	switch d.Field {
+	case 1:
+		id.Subnet = d.String()
+	case 2:
+		id.Name = d.String()
+	}
+}
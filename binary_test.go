@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func binaryTestMessages() []SSMessage {
+	ts := time.Unix(1400000000, 123456789).UTC()
+	client := SSClientId{Server: "hub.a", Subnet: "dev", Nick: "test"}
+	channel := SSChannelId{Subnet: "dev", Name: "help"}
+	return []SSMessage{
+		&SSHello{Protocol: 2, LocalTimeMs: 1400000000000, Name: "hub.a", Description: "Test Server", DefaultSubnet: "default", MinProtocol: 1, Capabilities: []string{CAP_KEEPALIVE, CAP_BINARY}, NetName: "TestNet"},
+		&SSBurstComplete{Server: "hub.a"},
+		&SSSync{Sequence: 3, Reply: true, Origin: "hub.a", ReplyFrom: "hub.b"},
+		&SSClient{Subnet: "dev", Server: "hub.a", Nick: "test", Ident: "ident", Vident: "vident", Host: "host", Vhost: "vhost", Ip: "127.0.0.1", Vip: "0.0.0.0", Gecos: "Real Name", Ts: ts},
+		&SSServer{Name: "hub.b", Desc: "Test Server", Via: "hub.a"},
+		&SSKill{Id: client, Server: "hub.a", Authority: true, By: SSClientId{Server: "hub.b", Subnet: "dev", Nick: "oper"}, Reason: "bye", ReasonCode: SS_KILL_REASON_COLLISION},
+		&SSSplit{Server: "hub.b", Reason: "gone"},
+		&SSChannel{Name: "help", Subnet: "dev", Ts: ts, Members: []*SSMembership{
+			{Client: client, Channel: channel, Ts: ts, IsOp: true},
+			{Client: SSClientId{Server: "hub.b", Subnet: "dev", Nick: "other"}, Channel: channel, Ts: ts, IsVoice: true},
+		}, NoExternalMessages: true, TopicProtected: true, Secret: true, InviteOnly: true, Limit: 20, Key: "key", Topic: "Welcome", TopicTs: ts, TopicBy: "test",
+			Lists: []SSListEntry{{List: LIST_BAN, Mask: "*!*@bad", SetBy: "test", SetTs: ts}, {List: LIST_INVITE_EXCEPT, Mask: "*!*@good", SetBy: "test", SetTs: ts}}},
+		&SSMembership{Client: client, Channel: channel, Ts: ts, IsOwner: true, IsAdmin: true, IsOp: true, IsHalfop: true, IsVoice: true},
+		&SSMembershipEnd{Channel: channel, Client: client, Reason: "leaving"},
+		&SSPrivateMessage{From: client, To: SSClientId{Server: "hub.b", Subnet: "dev", Nick: "other"}, Message: "hello"},
+		&SSChannelMessage{From: client, To: channel, Message: "hello"},
+		&SSChannelMode{From: client, Channel: channel,
+			Mode: SSChannelModeDelta{Moderated: SS_MODE_ADDED, Secret: SS_MODE_REMOVED, InviteOnly: SS_MODE_ADDED, Limit: SS_MODE_ADDED, LimitValue: 10, Key: SS_MODE_ADDED, KeyValue: "key",
+				Lists: []SSListModeDelta{{List: LIST_EXCEPT, Change: SS_MODE_ADDED, Mask: "*!*@good", SetBy: "test", SetTs: ts}}},
+			MemberMode: []SSMemberModeDelta{
+				{Client: client, IsOp: SS_MODE_REMOVED, IsVoice: SS_MODE_ADDED},
+			},
+		},
+		&SSPing{Cookie: 42},
+		&SSPong{Cookie: 42},
+		&SSError{Reason: "closing"},
+		&SSTopic{From: client, Channel: channel, Topic: "Welcome", Ts: ts, By: "test"},
+		&SSNick{Id: client, Nick: "Renamed", Ts: ts},
+		&SSMove{Id: client, Subnet: "dev", Nick: "Moved", Ts: ts},
+		&SSSubnet{Name: "dev", DisplayName: "Developers", Owner: "test", Ts: ts, ForeignJoin: FOREIGN_JOIN_INVITE, PolicyTs: ts},
+		&SSSubnetEnd{Name: "dev", Server: "hub.a"},
+		&SSSubnetPolicy{Name: "dev", ForeignJoin: FOREIGN_JOIN_CLOSED, Ts: ts},
+		&SSInvite{From: client, To: SSClientId{Server: "hub.b", Subnet: "dev", Nick: "other"}, Channel: channel},
+		&SSKick{From: client, Channel: channel, Target: SSClientId{Server: "hub.b", Subnet: "dev", Nick: "other"}, Reason: "out", ChannelTs: ts, Ts: ts},
+	}
+}
+
+func TestBinary_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := BinaryServerProtocolFactory.Writer(&buf)
+	r := BinaryServerProtocolFactory.Reader(&buf)
+	for _, msg := range binaryTestMessages() {
+		err := w.WriteMessage(msg)
+		if err != nil {
+			t.Fatalf("Failed to write %v: %v", msg, err)
+		}
+		decoded, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read %v: %v", msg, err)
+		}
+		if !reflect.DeepEqual(msg, decoded) {
+			t.Errorf("Expected %#v, got %#v", msg, decoded)
+		}
+	}
+}
+
+// Every message type must have a binary encoding.
+func TestBinary_AllMessages(t *testing.T) {
+	for msgType, ctor := range constructorMap {
+		msg := ctor()
+		if msg.messageType() != msgType {
+			t.Errorf("%T: registered as type %d, reports type %d", msg, msgType, msg.messageType())
+		}
+		if _, ok := msg.(binaryEncodable); !ok {
+			t.Errorf("%T: no binary encoding", msg)
+		}
+		if _, ok := msg.(binaryDecodable); !ok {
+			t.Errorf("%T: no binary decoding", msg)
+		}
+	}
+}
+
+// Fields with unknown numbers, such as those added by a newer schema, are
+// skipped regardless of where they appear.
+func TestBinary_UnknownFields(t *testing.T) {
+	e := &binaryEncoder{}
+	e.String(99, "from the future")
+	e.String(1, "hub.b")
+	e.Uint(100, 7)
+	e.Message(101, SSChannelId{Subnet: "dev", Name: "help"})
+	e.String(3, "hub.a")
+
+	var msg SSServer
+	err := decodeBinary(e.buf, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != (SSServer{Name: "hub.b", Via: "hub.a"}) {
+		t.Errorf("Expected server(hub.b via hub.a), got %v", msg)
+	}
+}
+
+func TestBinary_Truncated(t *testing.T) {
+	e := &binaryEncoder{}
+	(SSServer{Name: "hub.b", Desc: "Test Server", Via: "hub.a"}).encodeBinary(e)
+
+	var msg SSServer
+	err := decodeBinary(e.buf[:len(e.buf)-2], &msg)
+	if _, ok := err.(BinaryEncodingError); !ok {
+		t.Errorf("Expected BinaryEncodingError, got %v", err)
+	}
+}
+
+func TestBinary_LinkProtocol(t *testing.T) {
+	if linkProtocol(map[string]bool{CAP_FRAMED: true, CAP_BINARY: true}) != BinaryServerProtocolFactory {
+		t.Errorf("Expected the binary protocol when both servers support it")
+	}
+	if linkProtocol(map[string]bool{CAP_FRAMED: true}) != FramedGobServerProtocolFactory {
+		t.Errorf("Expected the framed gob protocol without the binary capability")
+	}
+}
@@ -0,0 +1,263 @@
+// Package ca implements the certificate authority of a Gossamer network.
+//
+// Every Gossamer network has a single root, from which two kinds of
+// certificates are issued:
+//
+// Server certificates identify a server on the network. The subject common
+// name and the only DNS name are both the server name (Config.ServerName),
+// which must be the name the server announces when linking. They are valid
+// for both server and client authentication, since either side of a link may
+// have initiated the connection.
+//
+// Client certificates identify a user account. The subject common name is the
+// account name, and the privileges granted to the account are carried as
+// URIs of the form "gossamer:privilege:<name>". They are only valid for client
+// authentication, and so can never be used to link a server.
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Privileges which may be granted to a client certificate.
+const (
+	PrivilegeKill     = "kill"
+	PrivilegeOverride = "override"
+	PrivilegeServices = "services"
+)
+
+const privilegeScheme = "gossamer"
+const privilegePrefix = "privilege:"
+
+var NotACertificateAuthority error = errors.New("NotACertificateAuthority")
+var NoPEMData error = errors.New("NoPEMData")
+
+// The network root: a self-signed CA certificate and its key.
+type Authority struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+// A certificate issued by an Authority, along with its key.
+type Credential struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+// Create a new network root for the named network.
+func New(netName string, validity time.Duration) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   netName + " Network CA",
+			Organization: []string{netName},
+		},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{cert, key}, nil
+}
+
+// Load a network root from its PEM encoded certificate and key.
+func Load(certPEM, keyPEM []byte) (*Authority, error) {
+	cred, err := LoadCredential(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !cred.Cert.IsCA {
+		return nil, NotACertificateAuthority
+	}
+	return &Authority{cred.Cert, cred.Key}, nil
+}
+
+// A pool containing only the network root, suitable for Config.NetworkCA.
+func (a *Authority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.Cert)
+	return pool
+}
+
+func (a *Authority) CertPEM() []byte {
+	return encodeCert(a.Cert)
+}
+
+func (a *Authority) KeyPEM() ([]byte, error) {
+	return encodeKey(a.Key)
+}
+
+// Issue a certificate for the server with the given name.
+func (a *Authority) IssueServer(serverName string, validity time.Duration) (*Credential, error) {
+	template := &x509.Certificate{
+		Subject:     a.subject(serverName),
+		DNSNames:    []string{serverName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	return a.issue(template, validity)
+}
+
+// Issue a certificate for a user account, granting the given privileges.
+// Accounts without privileges are ordinary users.
+func (a *Authority) IssueClient(account string, privileges []string, validity time.Duration) (*Credential, error) {
+	template := &x509.Certificate{
+		Subject:     a.subject(account),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, privilege := range privileges {
+		template.URIs = append(template.URIs, &url.URL{
+			Scheme: privilegeScheme,
+			Opaque: privilegePrefix + privilege,
+		})
+	}
+	return a.issue(template, validity)
+}
+
+// Create a DER encoded certificate revocation list revoking the certificates
+// with the given serial numbers. The number must increase with every list
+// issued, so that servers can discard older lists.
+func (a *Authority) RevocationList(revoked []*big.Int, number *big.Int, validity time.Duration) ([]byte, error) {
+	now := time.Now()
+	entries := make([]x509.RevocationListEntry, len(revoked))
+	for idx, serial := range revoked {
+		entries[idx] = x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: now,
+		}
+	}
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+	}
+	return x509.CreateRevocationList(rand.Reader, template, a.Cert, a.Key)
+}
+
+func (a *Authority) subject(name string) pkix.Name {
+	return pkix.Name{
+		CommonName:   name,
+		Organization: a.Cert.Subject.Organization,
+	}
+}
+
+func (a *Authority) issue(template *x509.Certificate, validity time.Duration) (*Credential, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber, err = newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template.NotBefore = now.Add(-time.Minute)
+	template.NotAfter = now.Add(validity)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Cert, &key.PublicKey, a.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{cert, key}, nil
+}
+
+// Load a credential from its PEM encoded certificate and key.
+func LoadCredential(certPEM, keyPEM []byte) (*Credential, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, NoPEMData
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{cert, key}, nil
+}
+
+func (c *Credential) TLSCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.Cert.Raw},
+		PrivateKey:  c.Key,
+		Leaf:        c.Cert,
+	}
+}
+
+func (c *Credential) CertPEM() []byte {
+	return encodeCert(c.Cert)
+}
+
+func (c *Credential) KeyPEM() ([]byte, error) {
+	return encodeKey(c.Key)
+}
+
+// The account (or server) name a certificate was issued to.
+func Name(cert *x509.Certificate) string {
+	return cert.Subject.CommonName
+}
+
+// The privileges granted by a client certificate. The certificate should have
+// been verified against the network root first.
+func Privileges(cert *x509.Certificate) []string {
+	privileges := make([]string, 0)
+	for _, uri := range cert.URIs {
+		if uri.Scheme == privilegeScheme && strings.HasPrefix(uri.Opaque, privilegePrefix) {
+			privileges = append(privileges, strings.TrimPrefix(uri.Opaque, privilegePrefix))
+		}
+	}
+	return privileges
+}
+
+func encodeCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,108 @@
+package ca
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestAuthority(t *testing.T) *Authority {
+	a, err := New("TestNet", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestIssueServer(t *testing.T) {
+	a := newTestAuthority(t)
+	cred, err := a.IssueServer("hub.a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cred.Cert.Verify(x509.VerifyOptions{
+		Roots:     a.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Errorf("Server certificate doesn't verify: %v", err)
+	}
+	if err = cred.Cert.VerifyHostname("hub.a"); err != nil {
+		t.Errorf("Server certificate not bound to its name: %v", err)
+	}
+	if Name(cred.Cert) != "hub.a" {
+		t.Errorf("Expected 'hub.a', got '%s'", Name(cred.Cert))
+	}
+}
+
+func TestIssueClient(t *testing.T) {
+	a := newTestAuthority(t)
+	cred, err := a.IssueClient("alpha", []string{PrivilegeKill, PrivilegeOverride}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privileges := Privileges(cred.Cert)
+	if len(privileges) != 2 || privileges[0] != PrivilegeKill || privileges[1] != PrivilegeOverride {
+		t.Errorf("Unexpected privileges: %v", privileges)
+	}
+
+	// Client certificates must not be usable to link servers.
+	_, err = cred.Cert.Verify(x509.VerifyOptions{
+		Roots:     a.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err == nil {
+		t.Error("Client certificate verified for server authentication")
+	}
+}
+
+func TestLoadRoundTrip(t *testing.T) {
+	a := newTestAuthority(t)
+	keyPEM, err := a.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(a.CertPEM(), keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(a.Cert) {
+		t.Error("Loaded certificate differs")
+	}
+
+	// A non-CA credential isn't an authority.
+	cred, err := a.IssueServer("hub.a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err = cred.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(cred.CertPEM(), keyPEM); err != NotACertificateAuthority {
+		t.Errorf("Expected NotACertificateAuthority, got %v", err)
+	}
+}
+
+func TestRevocationList(t *testing.T) {
+	a := newTestAuthority(t)
+	cred, err := a.IssueServer("hub.a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := a.RevocationList([]*big.Int{cred.Cert.SerialNumber}, big.NewInt(1), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = crl.CheckSignatureFrom(a.Cert); err != nil {
+		t.Errorf("Revocation list not signed by the network root: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(cred.Cert.SerialNumber) != 0 {
+		t.Error("Revocation list doesn't revoke the issued certificate")
+	}
+}
@@ -38,14 +38,86 @@ type Channel struct {
 	LocalMember map[*Client]*Membership
 	Member      map[*Client]*Membership
 
-	Mode struct {
-		TopicProtected     bool
-		NoExternalMessages bool
-		Moderated          bool
-		Secret             bool
-		Limit              uint32
-		Key                string
+	// Local clients invited to the channel, who haven't joined yet.
+	Invites map[*Client]struct{}
+
+	// Ban (+b), exception (+e) and invite exception (+I) lists.
+	Bans, Excepts, InviteExcepts ChannelList
+
+	Mode ChannelModes
+}
+
+// Channel-level modes. A Limit of zero means no limit, and an empty Key no key.
+type ChannelModes struct {
+	TopicProtected     bool
+	NoExternalMessages bool
+	Moderated          bool
+	Secret             bool
+	InviteOnly         bool
+	Limit              uint32
+	Key                string
+}
+
+// The delta which turns one set of channel modes into another.
+func (from ChannelModes) DeltaTo(to ChannelModes) ChannelModeDelta {
+	delta := ChannelModeDelta{
+		TopicProtected:     boolDelta(from.TopicProtected, to.TopicProtected),
+		NoExternalMessages: boolDelta(from.NoExternalMessages, to.NoExternalMessages),
+		Moderated:          boolDelta(from.Moderated, to.Moderated),
+		Secret:             boolDelta(from.Secret, to.Secret),
+		InviteOnly:         boolDelta(from.InviteOnly, to.InviteOnly),
+	}
+	if from.Limit != to.Limit {
+		if to.Limit == 0 {
+			delta.Limit = MODE_REMOVED
+		} else {
+			delta.Limit = MODE_ADDED
+			delta.LimitValue = to.Limit
+		}
 	}
+	if from.Key != to.Key {
+		if to.Key == "" {
+			delta.Key = MODE_REMOVED
+		} else {
+			delta.Key = MODE_ADDED
+			delta.KeyValue = to.Key
+		}
+	}
+	return delta
+}
+
+func boolDelta(from, to bool) ModeDelta {
+	switch {
+	case to && !from:
+		return MODE_ADDED
+	case from && !to:
+		return MODE_REMOVED
+	default:
+		return MODE_UNCHANGED
+	}
+}
+
+// Merge the modes of two copies of a channel with the same timestamp. A mode
+// set on either side is set. When both sides set a key or limit, the greater
+// one wins, so that every server reaches the same result whichever side it's
+// on.
+func mergeChannelModes(a, b ChannelModes) ChannelModes {
+	merged := ChannelModes{
+		TopicProtected:     a.TopicProtected || b.TopicProtected,
+		NoExternalMessages: a.NoExternalMessages || b.NoExternalMessages,
+		Moderated:          a.Moderated || b.Moderated,
+		Secret:             a.Secret || b.Secret,
+		InviteOnly:         a.InviteOnly || b.InviteOnly,
+		Limit:              a.Limit,
+		Key:                a.Key,
+	}
+	if b.Limit > merged.Limit {
+		merged.Limit = b.Limit
+	}
+	if b.Key > merged.Key {
+		merged.Key = b.Key
+	}
+	return merged
 }
 
 func NewChannel(node *Node, subnet *Subnet, name string) *Channel {
@@ -56,6 +128,11 @@ func NewChannel(node *Node, subnet *Subnet, name string) *Channel {
 		Lname:       strings.ToLower(name),
 		LocalMember: make(map[*Client]*Membership),
 		Member:      make(map[*Client]*Membership),
+		Invites:     make(map[*Client]struct{}),
+
+		Bans:          make(ChannelList),
+		Excepts:       make(ChannelList),
+		InviteExcepts: make(ChannelList),
 	}
 }
 
@@ -76,6 +153,20 @@ func (ch *Channel) Serialize() *SSChannel {
 		Subnet:  ch.Subnet.Name,
 		Ts:      ch.Ts,
 		Members: make([]*SSMembership, 0, len(ch.Member)),
+
+		TopicProtected:     ch.Mode.TopicProtected,
+		NoExternalMessages: ch.Mode.NoExternalMessages,
+		Moderated:          ch.Mode.Moderated,
+		Secret:             ch.Mode.Secret,
+		InviteOnly:         ch.Mode.InviteOnly,
+		Limit:              ch.Mode.Limit,
+		Key:                ch.Mode.Key,
+
+		Topic:   ch.Topic,
+		TopicTs: ch.TopicTs,
+		TopicBy: ch.TopicBy,
+
+		Lists: ch.serializeLists(),
 	}
 	for client, member := range ch.Member {
 		msg.Members = append(msg.Members, member.Serialize(ch, client))
@@ -83,6 +174,65 @@ func (ch *Channel) Serialize() *SSChannel {
 	return msg
 }
 
+// The most members and list entries carried by one message in a channel
+// burst, which keeps big channels well under the frame size limit.
+const (
+	burstMembersPerMessage     = 500
+	burstListEntriesPerMessage = 100
+)
+
+// Serialize the channel for a burst. Big channels are split into several
+// messages with the same timestamp, modes and topic, each carrying some of
+// the members and list entries, which the receiver merges back together.
+func (ch *Channel) SerializeBurst() []*SSChannel {
+	full := ch.Serialize()
+	members, lists := full.Members, full.Lists
+	msgs := make([]*SSChannel, 0, 1)
+	for {
+		msg := *full
+		msg.Members = members
+		if len(members) > burstMembersPerMessage {
+			msg.Members = members[:burstMembersPerMessage]
+		}
+		msg.Lists = lists
+		if len(lists) > burstListEntriesPerMessage {
+			msg.Lists = lists[:burstListEntriesPerMessage]
+		}
+		members = members[len(msg.Members):]
+		lists = lists[len(msg.Lists):]
+		msgs = append(msgs, &msg)
+		if len(members) == 0 && len(lists) == 0 {
+			return msgs
+		}
+	}
+}
+
+// Take the topic of a channel from a serialized copy, returning whether it
+// changed.
+func (ch *Channel) adoptTopic(msg *SSChannel) bool {
+	changed := ch.Topic != msg.Topic
+	ch.Topic = msg.Topic
+	ch.TopicTs = msg.TopicTs
+	ch.TopicBy = msg.TopicBy
+	return changed
+}
+
+// Whether the topic in a serialized copy of a channel with the same timestamp
+// should replace this one.
+func (ch *Channel) preferTopic(msg *SSChannel) bool {
+	return ch.newerTopic(msg.Topic, msg.TopicTs)
+}
+
+// Whether a topic set at ts should replace the current one. The most recently
+// set topic wins, and the greater topic breaks ties, so that every server
+// reaches the same result whichever order changes arrive in.
+func (ch *Channel) newerTopic(topic string, ts time.Time) bool {
+	if ts.Equal(ch.TopicTs) {
+		return topic > ch.Topic
+	}
+	return ts.After(ch.TopicTs)
+}
+
 func (ch *Channel) ApplyModeDelta(delta ChannelModeDelta, memberDelta []MemberModeDelta) (ChannelModeDelta, []MemberModeDelta) {
 	outDelta := ChannelModeDelta{}
 	outMember := make([]MemberModeDelta, 0)
@@ -95,10 +245,10 @@ func (ch *Channel) ApplyModeDelta(delta ChannelModeDelta, memberDelta []MemberMo
 	}
 	if delta.NoExternalMessages == MODE_ADDED && !ch.Mode.NoExternalMessages {
 		ch.Mode.NoExternalMessages = true
-		outDelta.Moderated = MODE_ADDED
+		outDelta.NoExternalMessages = MODE_ADDED
 	} else if delta.NoExternalMessages == MODE_REMOVED && ch.Mode.NoExternalMessages {
 		ch.Mode.NoExternalMessages = false
-		outDelta.Moderated = MODE_REMOVED
+		outDelta.NoExternalMessages = MODE_REMOVED
 	}
 	if delta.Secret == MODE_ADDED && !ch.Mode.Secret {
 		ch.Mode.Secret = true
@@ -114,6 +264,34 @@ func (ch *Channel) ApplyModeDelta(delta ChannelModeDelta, memberDelta []MemberMo
 		ch.Mode.TopicProtected = false
 		outDelta.TopicProtected = MODE_REMOVED
 	}
+	if delta.InviteOnly == MODE_ADDED && !ch.Mode.InviteOnly {
+		ch.Mode.InviteOnly = true
+		outDelta.InviteOnly = MODE_ADDED
+	} else if delta.InviteOnly == MODE_REMOVED && ch.Mode.InviteOnly {
+		ch.Mode.InviteOnly = false
+		outDelta.InviteOnly = MODE_REMOVED
+	}
+	if delta.Limit == MODE_ADDED && delta.LimitValue != 0 && ch.Mode.Limit != delta.LimitValue {
+		ch.Mode.Limit = delta.LimitValue
+		outDelta.Limit = MODE_ADDED
+		outDelta.LimitValue = delta.LimitValue
+	} else if delta.Limit == MODE_REMOVED && ch.Mode.Limit != 0 {
+		ch.Mode.Limit = 0
+		outDelta.Limit = MODE_REMOVED
+	}
+	if delta.Key == MODE_ADDED && delta.KeyValue != "" && ch.Mode.Key != delta.KeyValue {
+		ch.Mode.Key = delta.KeyValue
+		outDelta.Key = MODE_ADDED
+		outDelta.KeyValue = delta.KeyValue
+	} else if delta.Key == MODE_REMOVED && ch.Mode.Key != "" {
+		ch.Mode.Key = ""
+		outDelta.Key = MODE_REMOVED
+	}
+	for _, list := range delta.Lists {
+		if ch.applyListDelta(list) {
+			outDelta.Lists = append(outDelta.Lists, list)
+		}
+	}
 	for _, member := range memberDelta {
 		membership, found := ch.Member[member.Client]
 		if !found {
@@ -173,9 +351,10 @@ func (ch *Channel) ApplyModeDelta(delta ChannelModeDelta, memberDelta []MemberMo
 
 type ChannelModeDelta struct {
 	TopicProtected, NoExternalMessages, Moderated, Secret ModeDelta
-	Limit, Key                                            ModeDelta
+	InviteOnly, Limit, Key                                ModeDelta
 	LimitValue                                            uint32
 	KeyValue                                              string
+	Lists                                                 []ListModeDelta
 }
 
 func (cmd *ChannelModeDelta) IsEmpty() bool {
@@ -183,11 +362,15 @@ func (cmd *ChannelModeDelta) IsEmpty() bool {
 		cmd.Moderated == MODE_UNCHANGED &&
 		cmd.NoExternalMessages == MODE_UNCHANGED &&
 		cmd.Secret == MODE_UNCHANGED &&
-		cmd.TopicProtected == MODE_UNCHANGED
+		cmd.TopicProtected == MODE_UNCHANGED &&
+		cmd.InviteOnly == MODE_UNCHANGED &&
+		cmd.Limit == MODE_UNCHANGED &&
+		cmd.Key == MODE_UNCHANGED &&
+		len(cmd.Lists) == 0
 }
 
 func (cmd *ChannelModeDelta) String() string {
-	return fmt.Sprintf("m=%s, n=%s, s=%s, t=%s", cmd.Moderated.String(), cmd.NoExternalMessages.String(), cmd.Secret.String(), cmd.TopicProtected.String())
+	return fmt.Sprintf("m=%s, n=%s, s=%s, t=%s, i=%s, l=%s(%d), k=%s(%s)", cmd.Moderated.String(), cmd.NoExternalMessages.String(), cmd.Secret.String(), cmd.TopicProtected.String(), cmd.InviteOnly.String(), cmd.Limit.String(), cmd.LimitValue, cmd.Key.String(), cmd.KeyValue)
 }
 
 type Membership struct {
@@ -195,6 +378,54 @@ type Membership struct {
 	IsOwner, IsAdmin, IsOp, IsHalfop, IsVoice bool
 }
 
+type MemberRank uint8
+
+// Membership ranks, from lowest to highest.
+const (
+	RANK_NONE MemberRank = iota
+	RANK_VOICE
+	RANK_HALFOP
+	RANK_OP
+	RANK_ADMIN
+	RANK_OWNER
+)
+
+// The highest rank held by the member.
+func (m *Membership) Rank() MemberRank {
+	switch {
+	case m.IsOwner:
+		return RANK_OWNER
+	case m.IsAdmin:
+		return RANK_ADMIN
+	case m.IsOp:
+		return RANK_OP
+	case m.IsHalfop:
+		return RANK_HALFOP
+	case m.IsVoice:
+		return RANK_VOICE
+	default:
+		return RANK_NONE
+	}
+}
+
+// Whether a member may kick another, by the same ranks as FilterChannelModes:
+// owners may kick anyone, admins anyone up to admin, ops anyone up to op and
+// halfops anyone up to halfop.
+func (m *Membership) CanKick(target *Membership) bool {
+	switch {
+	case m.IsOwner:
+		return true
+	case m.IsAdmin:
+		return target.Rank() <= RANK_ADMIN
+	case m.IsOp:
+		return target.Rank() <= RANK_OP
+	case m.IsHalfop:
+		return target.Rank() <= RANK_HALFOP
+	default:
+		return false
+	}
+}
+
 func (m *Membership) Serialize(channel *Channel, client *Client) *SSMembership {
 	return &SSMembership{
 		Channel:  channel.Id(),
@@ -15,6 +15,10 @@ type Client struct {
 	Gecos         string
 	Ts            time.Time
 	Member        map[*Channel]*Membership
+
+	// Whether the client is an IRC operator, as set by the frontend. Only
+	// kept for local clients.
+	IsOper bool
 }
 
 func (c *Client) Id() SSClientId {
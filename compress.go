@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"compress/flate"
+	"io"
+)
+
+// Wrap a protocol so that the stream it reads and writes is DEFLATE
+// compressed. Messages are held in the compressor until the writer is flushed,
+// which a Node does once it has finished handling each event. Consecutive
+// messages, such as those of a burst, are compressed together, while a single
+// message is never held back waiting for more.
+func CompressedServerProtocolFactory(inner ServerProtocolFactory) ServerProtocolFactory {
+	return &compressedServerProtocolFactory{inner, flate.DefaultCompression}
+}
+
+type compressedServerProtocolFactory struct {
+	inner ServerProtocolFactory
+	level int
+}
+
+func (cspf compressedServerProtocolFactory) Reader(reader io.Reader) ServerProtocolReader {
+	// The decompressor reads no further than the end of the compressed
+	// stream's current block.
+	return cspf.inner.Reader(flate.NewReader(reader))
+}
+
+func (cspf compressedServerProtocolFactory) Writer(writer io.Writer) ServerProtocolWriter {
+	compressor, err := flate.NewWriter(writer, cspf.level)
+	if err != nil {
+		// Only possible with an invalid level.
+		panic(err)
+	}
+	return &compressedServerProtocolWriter{cspf.inner.Writer(compressor), compressor}
+}
+
+type compressedServerProtocolWriter struct {
+	writer     ServerProtocolWriter
+	compressor *flate.Writer
+}
+
+func (cspw *compressedServerProtocolWriter) WriteMessage(msg SSMessage) error {
+	return cspw.writer.WriteMessage(msg)
+}
+
+func (cspw *compressedServerProtocolWriter) Flush() error {
+	return cspw.compressor.Flush()
+}
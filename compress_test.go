@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// Each message must be readable as soon as the writer is flushed, without
+// waiting for the compressor to see more data.
+func TestCompressed_FlushPerMessage(t *testing.T) {
+	factory := CompressedServerProtocolFactory(BinaryServerProtocolFactory)
+	r, w := io.Pipe()
+	writer := factory.Writer(w)
+	reader := factory.Reader(r)
+
+	recv := make(chan SSMessage)
+	go func() {
+		defer close(recv)
+		for {
+			msg, err := reader.ReadMessage()
+			if err != nil {
+				return
+			}
+			recv <- msg
+		}
+	}()
+
+	// A single goroutine writes the messages in order, waiting for each one
+	// to be read before writing the next.
+	msgs := binaryTestMessages()
+	read := make(chan struct{})
+	writeErr := make(chan error, 1)
+	go func() {
+		defer close(writeErr)
+		for _, msg := range msgs {
+			if err := writer.WriteMessage(msg); err != nil {
+				writeErr <- err
+				return
+			}
+			if err := writer.(flushingServerProtocolWriter).Flush(); err != nil {
+				writeErr <- err
+				return
+			}
+			if _, ok := <-read; !ok {
+				return
+			}
+		}
+	}()
+
+	for _, msg := range msgs {
+		decoded, ok := <-recv
+		if !ok {
+			r.Close()
+			close(read)
+			t.Fatalf("Reader failed before %v: %v", msg, <-writeErr)
+		}
+		if decoded.String() != msg.String() {
+			t.Errorf("Expected %v, got %v", msg, decoded)
+		}
+		read <- struct{}{}
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	<-recv
+}
+
+func TestCompressed_Burst(t *testing.T) {
+	var plain, compressed bytes.Buffer
+	plainWriter := BinaryServerProtocolFactory.Writer(&plain)
+	compressedWriter := CompressedServerProtocolFactory(BinaryServerProtocolFactory).Writer(&compressed)
+
+	burst := make([]SSMessage, 0, 200)
+	for i := 0; i < cap(burst); i++ {
+		burst = append(burst, &SSClient{
+			Subnet: "default",
+			Server: "hub.a",
+			Nick:   fmt.Sprintf("user%d", i),
+			Ident:  "ident",
+			Vident: "ident",
+			Host:   "host.example.com",
+			Vhost:  "hidden.example.com",
+			Gecos:  "Gossamer User",
+		})
+	}
+	for _, msg := range burst {
+		plainWriter.WriteMessage(msg)
+		compressedWriter.WriteMessage(msg)
+	}
+	compressedWriter.(flushingServerProtocolWriter).Flush()
+	if compressed.Len() >= plain.Len() {
+		t.Errorf("Expected compression, got %d bytes from %d", compressed.Len(), plain.Len())
+	}
+
+	reader := CompressedServerProtocolFactory(BinaryServerProtocolFactory).Reader(&compressed)
+	for _, msg := range burst {
+		decoded, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.String() != msg.String() {
+			t.Errorf("Expected %v, got %v", msg, decoded)
+		}
+	}
+}
+
+func TestCompressed_LinkProtocol(t *testing.T) {
+	protocol := linkProtocol(map[string]bool{CAP_BINARY: true, CAP_DEFLATE: true})
+	compressed, ok := protocol.(*compressedServerProtocolFactory)
+	if !ok || compressed.inner != BinaryServerProtocolFactory {
+		t.Errorf("Expected compressed binary protocol, got %v", protocol)
+	}
+	protocol = linkProtocol(map[string]bool{CAP_DEFLATE: true})
+	compressed, ok = protocol.(*compressedServerProtocolFactory)
+	if !ok || compressed.inner != GobServerProtocolFactory {
+		t.Errorf("Expected compressed gob protocol, got %v", protocol)
+	}
+}
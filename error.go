@@ -1,5 +1,10 @@
 package lib
 
+import (
+	"fmt"
+	"time"
+)
+
 type NameInUseError struct{}
 
 func (_ NameInUseError) Error() string {
@@ -11,3 +16,215 @@ type AlreadyAMemberError struct{}
 func (_ AlreadyAMemberError) Error() string {
 	return "AlreadyAMember"
 }
+
+type BadChannelKeyError struct{}
+
+func (_ BadChannelKeyError) Error() string {
+	return "BadChannelKey"
+}
+
+type ChannelFullError struct{}
+
+func (_ ChannelFullError) Error() string {
+	return "ChannelFull"
+}
+
+type BannedFromChannelError struct{}
+
+func (_ BannedFromChannelError) Error() string {
+	return "BannedFromChannel"
+}
+
+// A nick change refused because the client is banned on a channel it's in.
+type BannedNickChangeError struct {
+	Channel *Channel
+}
+
+func (err BannedNickChangeError) Error() string {
+	return fmt.Sprintf("BannedNickChange(%s)", err.Channel.Name)
+}
+
+type NoExternalMessagesError struct{}
+
+func (_ NoExternalMessagesError) Error() string {
+	return "NoExternalMessages"
+}
+
+type ModeratedChannelError struct{}
+
+func (_ ModeratedChannelError) Error() string {
+	return "ModeratedChannel"
+}
+
+type NoPrivilegesError struct{}
+
+func (_ NoPrivilegesError) Error() string {
+	return "NoPrivileges"
+}
+
+type NotOnChannelError struct{}
+
+func (_ NotOnChannelError) Error() string {
+	return "NotOnChannel"
+}
+
+type UserNotOnChannelError struct{}
+
+func (_ UserNotOnChannelError) Error() string {
+	return "UserNotOnChannel"
+}
+
+type ChannelPrivilegesNeededError struct{}
+
+func (_ ChannelPrivilegesNeededError) Error() string {
+	return "ChannelPrivilegesNeeded"
+}
+
+type ForeignJoinDeniedError struct{}
+
+func (_ ForeignJoinDeniedError) Error() string {
+	return "ForeignJoinDenied"
+}
+
+type InviteOnlyError struct{}
+
+func (_ InviteOnlyError) Error() string {
+	return "InviteOnly"
+}
+
+// The invited client's server can't be reached by a route which carries
+// invites.
+type InviteUnsupportedError struct{}
+
+func (_ InviteUnsupportedError) Error() string {
+	return "InviteUnsupported"
+}
+
+type SubnetExistsError struct{}
+
+func (_ SubnetExistsError) Error() string {
+	return "SubnetExists"
+}
+
+type SubnetNotEmptyError struct{}
+
+func (_ SubnetNotEmptyError) Error() string {
+	return "SubnetNotEmpty"
+}
+
+type DefaultSubnetError struct{}
+
+func (_ DefaultSubnetError) Error() string {
+	return "DefaultSubnet"
+}
+
+type LinkVerificationError struct {
+	Server string
+	Reason string
+}
+
+func (err LinkVerificationError) Error() string {
+	return fmt.Sprintf("LinkVerificationFailed(%s): %s", err.Server, err.Reason)
+}
+
+type PingTimeoutError struct {
+	Server  string
+	Elapsed time.Duration
+}
+
+func (err PingTimeoutError) Error() string {
+	return fmt.Sprintf("PingTimeout(%s): no reply in %v", err.Server, err.Elapsed)
+}
+
+type ProtocolVersionError struct {
+	Server               string
+	RemoteMin, RemoteMax uint32
+	LocalMin, LocalMax   uint32
+}
+
+func (err ProtocolVersionError) Error() string {
+	return fmt.Sprintf("ProtocolVersionMismatch(%s): speaks %d-%d, need %d-%d", err.Server, err.RemoteMin, err.RemoteMax, err.LocalMin, err.LocalMax)
+}
+
+type HandshakeError struct {
+	Server string
+	Reason string
+}
+
+func (err HandshakeError) Error() string {
+	return fmt.Sprintf("HandshakeFailed(%s): %s", err.Server, err.Reason)
+}
+
+// The reason given by a remote server for closing a link.
+type RemoteError struct {
+	Server string
+	Reason string
+}
+
+func (err RemoteError) Error() string {
+	return fmt.Sprintf("RemoteError(%s): %s", err.Server, err.Reason)
+}
+
+// A local server broke the s2s protocol, and its link has been split.
+type ProtocolViolationError struct {
+	Server string
+	Reason string
+}
+
+func (err ProtocolViolationError) Error() string {
+	return fmt.Sprintf("ProtocolViolation(%s): %s", err.Server, err.Reason)
+}
+
+type UnknownMessageTypeError struct {
+	Type uint32
+}
+
+func (err UnknownMessageTypeError) Error() string {
+	return fmt.Sprintf("UnknownMessageType(%d)", err.Type)
+}
+
+// A frame was received intact, but its payload could not be decoded. Later
+// frames are unaffected.
+type CorruptFrameError struct {
+	Type uint32
+	Err  error
+}
+
+func (err CorruptFrameError) Error() string {
+	return fmt.Sprintf("CorruptFrame(%d): %v", err.Type, err.Err)
+}
+
+type FrameTooLargeError struct {
+	Size, MaxSize uint32
+}
+
+func (err FrameTooLargeError) Error() string {
+	return fmt.Sprintf("FrameTooLarge: %d bytes, limit %d", err.Size, err.MaxSize)
+}
+
+type BinaryEncodingError struct {
+	Type   uint32
+	Reason string
+}
+
+func (err BinaryEncodingError) Error() string {
+	return fmt.Sprintf("BinaryEncoding(%d): %s", err.Type, err.Reason)
+}
+
+type StaleRevocationListError struct{}
+
+func (_ StaleRevocationListError) Error() string {
+	return "StaleRevocationList"
+}
+
+type ExpiredRevocationListError struct{}
+
+func (_ ExpiredRevocationListError) Error() string {
+	return "ExpiredRevocationList"
+}
+
+type NodeStoppedError struct{}
+
+func (_ NodeStoppedError) Error() string {
+	return "NodeStopped"
+}
@@ -6,7 +6,15 @@ type EventHandler interface {
 	OnChannelMessage(from *Client, to *Channel, message string)
 	OnChannelModeChange(channel *Channel, by *Client, delta ChannelModeDelta, memberDelta []MemberModeDelta)
 	OnPrivateMessage(from *Client, to *Client, message string)
+	OnInvite(from *Client, to *Client, channel *Channel)
 	OnChannelPart(channel *Channel, client *Client, reason string)
+	OnChannelKick(channel *Channel, by *Client, target *Client, reason string)
+	OnChannelTopic(channel *Channel, by *Client, topic string)
+	OnNickChange(client *Client, oldNick string)
+	OnClientMove(client *Client, oldSubnet *Subnet, oldNick string)
+	OnClientQuit(client *Client, reason string, code SSKillReason)
+	OnClientKilled(client *Client, by *Client, reason string, code SSKillReason)
+	OnProtocolViolation(server *Server, err error)
 }
 
 type ProxyEventHandler struct {
@@ -25,6 +33,12 @@ func (peh *ProxyEventHandler) OnPrivateMessage(from *Client, to *Client, message
 	}
 }
 
+func (peh *ProxyEventHandler) OnInvite(from *Client, to *Client, channel *Channel) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnInvite(from, to, channel)
+	}
+}
+
 func (peh *ProxyEventHandler) OnChannelJoin(channel *Channel, client *Client, membership *Membership) {
 	if peh.Delegate != nil {
 		peh.Delegate.OnChannelJoin(channel, client, membership)
@@ -48,3 +62,45 @@ func (peh *ProxyEventHandler) OnChannelPart(channel *Channel, client *Client, re
 		peh.Delegate.OnChannelPart(channel, client, reason)
 	}
 }
+
+func (peh *ProxyEventHandler) OnChannelKick(channel *Channel, by *Client, target *Client, reason string) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnChannelKick(channel, by, target, reason)
+	}
+}
+
+func (peh *ProxyEventHandler) OnChannelTopic(channel *Channel, by *Client, topic string) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnChannelTopic(channel, by, topic)
+	}
+}
+
+func (peh *ProxyEventHandler) OnNickChange(client *Client, oldNick string) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnNickChange(client, oldNick)
+	}
+}
+
+func (peh *ProxyEventHandler) OnClientMove(client *Client, oldSubnet *Subnet, oldNick string) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnClientMove(client, oldSubnet, oldNick)
+	}
+}
+
+func (peh *ProxyEventHandler) OnClientQuit(client *Client, reason string, code SSKillReason) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnClientQuit(client, reason, code)
+	}
+}
+
+func (peh *ProxyEventHandler) OnClientKilled(client *Client, by *Client, reason string, code SSKillReason) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnClientKilled(client, by, reason, code)
+	}
+}
+
+func (peh *ProxyEventHandler) OnProtocolViolation(server *Server, err error) {
+	if peh.Delegate != nil {
+		peh.Delegate.OnProtocolViolation(server, err)
+	}
+}
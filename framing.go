@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"log"
+)
+
+// Largest frame accepted by default, in bytes.
+const DefaultMaxFrameSize = 1 << 20
+
+// Size of the envelope preceding each frame's payload: the payload length and
+// the message type, both as big-endian uint32s.
+const frameHeaderSize = 8
+
+// Encodes and decodes the payload of a single frame. Each payload must be
+// self-contained, so that any frame can be skipped or lost without affecting
+// the others.
+type frameCodec interface {
+	Encode(msg SSMessage) ([]byte, error)
+	Decode(payload []byte, msg SSMessage) error
+}
+
+type gobFrameCodec struct{}
+
+func (_ gobFrameCodec) Encode(msg SSMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(msg)
+	return buf.Bytes(), err
+}
+
+func (_ gobFrameCodec) Decode(payload []byte, msg SSMessage) error {
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(msg)
+}
+
+// Protocol which wraps every message in a length-prefixed envelope. Frames with
+// an unknown message type are skipped, and a frame which fails to decode is
+// reported as a CorruptFrameError without affecting the frames that follow.
+var FramedGobServerProtocolFactory ServerProtocolFactory = &framedServerProtocolFactory{gobFrameCodec{}, DefaultMaxFrameSize}
+
+type framedServerProtocolFactory struct {
+	codec        frameCodec
+	maxFrameSize uint32
+}
+
+func (fspf framedServerProtocolFactory) Reader(reader io.Reader) ServerProtocolReader {
+	return &framedServerProtocolReader{reader, fspf.codec, fspf.maxFrameSize}
+}
+
+func (fspf framedServerProtocolFactory) Writer(writer io.Writer) ServerProtocolWriter {
+	return &framedServerProtocolWriter{writer, fspf.codec, fspf.maxFrameSize}
+}
+
+type framedServerProtocolReader struct {
+	reader       io.Reader
+	codec        frameCodec
+	maxFrameSize uint32
+}
+
+// Construct a ServerProtocolReader which reads gob encoded frames, refusing
+// any frame larger than maxFrameSize.
+func NewFramedGobServerProtocolReader(reader io.Reader, maxFrameSize uint32) ServerProtocolReader {
+	return &framedServerProtocolReader{reader, gobFrameCodec{}, maxFrameSize}
+}
+
+func (fspr *framedServerProtocolReader) ReadMessage() (msg SSMessage, err error) {
+	var header [frameHeaderSize]byte
+	for {
+		_, err = io.ReadFull(fspr.reader, header[:])
+		if err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		msgType := binary.BigEndian.Uint32(header[4:8])
+		if size > fspr.maxFrameSize {
+			// The frame could be skipped, but a peer sending frames this
+			// large is broken.
+			err = FrameTooLargeError{size, fspr.maxFrameSize}
+			return
+		}
+
+		payload := make([]byte, size)
+		_, err = io.ReadFull(fspr.reader, payload)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return
+		}
+
+		ctor, ok := constructorMap[msgType]
+		if !ok {
+			log.Printf("Skipping frame of unknown message type %d (%d bytes)", msgType, size)
+			continue
+		}
+		msg = ctor()
+		err = fspr.codec.Decode(payload, msg)
+		if err != nil {
+			err = CorruptFrameError{msgType, err}
+			msg = nil
+		}
+		return
+	}
+}
+
+type framedServerProtocolWriter struct {
+	writer       io.Writer
+	codec        frameCodec
+	maxFrameSize uint32
+}
+
+// Construct a ServerProtocolWriter which writes gob encoded frames, refusing
+// to write any frame larger than maxFrameSize.
+func NewFramedGobServerProtocolWriter(writer io.Writer, maxFrameSize uint32) ServerProtocolWriter {
+	return &framedServerProtocolWriter{writer, gobFrameCodec{}, maxFrameSize}
+}
+
+func (fspw *framedServerProtocolWriter) WriteMessage(msg SSMessage) error {
+	payload, err := fspw.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if uint32(len(payload)) > fspw.maxFrameSize {
+		return FrameTooLargeError{uint32(len(payload)), fspw.maxFrameSize}
+	}
+
+	// The envelope and payload are written together, so a frame is never
+	// interleaved with anything else.
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], msg.messageType())
+	copy(frame[frameHeaderSize:], payload)
+	_, err = fspw.writer.Write(frame)
+	return err
+}
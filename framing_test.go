@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+)
+
+func writeRawFrame(buf *bytes.Buffer, msgType uint32, payload []byte) {
+	var header [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], msgType)
+	buf.Write(header[:])
+	buf.Write(payload)
+}
+
+func TestFramed_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewFramedGobServerProtocolWriter(&buf, DefaultMaxFrameSize)
+	r := NewFramedGobServerProtocolReader(&buf, DefaultMaxFrameSize)
+
+	w.WriteMessage(&SSServer{Name: "hub.b", Desc: "Test Server", Via: "hub.a"})
+	w.WriteMessage(&SSSplit{Server: "hub.c", Reason: "gone"})
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, ok := msg.(*SSServer)
+	if !ok || server.Name != "hub.b" || server.Via != "hub.a" {
+		t.Errorf("Expected server(hub.b via hub.a), got %v", msg)
+	}
+	msg, err = r.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	split, ok := msg.(*SSSplit)
+	if !ok || split.Server != "hub.c" {
+		t.Errorf("Expected split(hub.c), got %v", msg)
+	}
+	if _, err = r.ReadMessage(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestFramed_SkipUnknownType(t *testing.T) {
+	var buf bytes.Buffer
+	writeRawFrame(&buf, 0xffff, []byte("from a future version"))
+	NewFramedGobServerProtocolWriter(&buf, DefaultMaxFrameSize).WriteMessage(&SSBurstComplete{"hub.b"})
+
+	msg, err := NewFramedGobServerProtocolReader(&buf, DefaultMaxFrameSize).ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*SSBurstComplete); !ok {
+		t.Errorf("Expected burstComplete, got %v", msg)
+	}
+}
+
+func TestFramed_CorruptFrame(t *testing.T) {
+	var buf bytes.Buffer
+	writeRawFrame(&buf, SS_MSG_TYPE_SERVER, []byte{0xde, 0xad, 0xbe, 0xef})
+	NewFramedGobServerProtocolWriter(&buf, DefaultMaxFrameSize).WriteMessage(&SSBurstComplete{"hub.b"})
+
+	r := NewFramedGobServerProtocolReader(&buf, DefaultMaxFrameSize)
+	_, err := r.ReadMessage()
+	if _, ok := err.(CorruptFrameError); !ok {
+		t.Fatalf("Expected CorruptFrameError, got %v", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*SSBurstComplete); !ok {
+		t.Errorf("Expected burstComplete after the corrupt frame, got %v", msg)
+	}
+}
+
+func TestFramed_TooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	writeRawFrame(&buf, SS_MSG_TYPE_SERVER, make([]byte, 100))
+	_, err := NewFramedGobServerProtocolReader(&buf, 64).ReadMessage()
+	if _, ok := err.(FrameTooLargeError); !ok {
+		t.Errorf("Expected FrameTooLargeError, got %v", err)
+	}
+
+	err = NewFramedGobServerProtocolWriter(&buf, 16).WriteMessage(&SSServer{Name: "hub.b", Desc: "A description too long for the frame"})
+	if _, ok := err.(FrameTooLargeError); !ok {
+		t.Errorf("Expected FrameTooLargeError, got %v", err)
+	}
+}
+
+// Verify that a negotiated link switches protocols after the hello without
+// losing the messages which follow it.
+func TestFramed_LinkUpgrade(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	r, w := io.Pipe()
+	recv := make(chan LinkMessage, 1)
+	l := NewNegotiatedLink(r, w, 1024, GobServerProtocolFactory, recv, wg)
+
+	l.WriteMessage(SSHello{Protocol: PROTOCOL_VERSION, Name: "server.name"})
+	if _, ok := (<-recv).msg.(*SSHello); !ok {
+		t.Fatalf("Expected SSHello message.")
+	}
+	l.Upgrade(FramedGobServerProtocolFactory)
+	l.WriteMessage(&SSBurstComplete{"server.name"})
+	if _, ok := (<-recv).msg.(*SSBurstComplete); !ok {
+		t.Fatalf("Expected SSBurstComplete message.")
+	}
+	l.Close()
+	wg.Wait()
+}
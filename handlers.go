@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 )
 
 func (n *Node) handleLinkMessage(msg SSMessage, from *Server) {
@@ -18,6 +19,7 @@ func (n *Node) handleLinkMessage(msg SSMessage, from *Server) {
 	case *SSBurstComplete:
 		log.Printf("[%s] burst from %s complete", n.Me.Name, msg.Server)
 		n.SendAllSkip(msg, from)
+		n.peerBurstComplete(from, msg.Server)
 		n.bumpVersion()
 	case *SSClient:
 		n.handleClient(msg, from)
@@ -35,49 +37,129 @@ func (n *Node) handleLinkMessage(msg SSMessage, from *Server) {
 		n.handleMembership(msg, from)
 	case *SSMembershipEnd:
 		n.handleMembershipEnd(msg, from)
+	case *SSKick:
+		n.handleKick(msg, from)
 	case *SSPrivateMessage:
 		n.handlePrivateMessage(msg, from)
 	case *SSChannelMessage:
 		n.handleChannelMessage(msg, from)
+	case *SSInvite:
+		n.handleInvite(msg, from)
 	case *SSChannelMode:
 		n.handleChannelMode(msg, from)
+	case *SSTopic:
+		n.handleTopic(msg, from)
+	case *SSNick:
+		n.handleNick(msg, from)
+	case *SSMove:
+		n.handleMove(msg, from)
+	case *SSSubnet:
+		n.handleSubnet(msg, from)
+	case *SSSubnetEnd:
+		n.handleSubnetEnd(msg, from)
+	case *SSSubnetPolicy:
+		n.handleSubnetPolicy(msg, from)
+	case *SSPing:
+		n.handlePing(msg, from)
+	case *SSPong:
+		n.handlePong(msg, from)
+	case *SSError:
+		n.split(from.Link, RemoteError{from.Name, msg.Reason})
 	}
 }
 
 func (n *Node) handleNewLinkMessage(msg LinkMessage, nl newLink) {
-	hello, ok := msg.msg.(*SSHello)
-	if !ok {
+	delete(n.NewLinks, msg.link)
+	defer n.handshakeDone(msg.link)
+	if msg.err != nil {
+		log.Printf("[%s] Error [%v] over new link {%s}", n.Me.Name, msg.err, msg.link.name)
+		msg.link.Silence = true
+		msg.link.Close()
 		return
 	}
 
-	delete(n.NewLinks, msg.link)
-	_, haveServer := n.Network[hello.Name]
-	if haveServer {
-		log.Printf("[%s] Already have server: %s", n.Me.Name, hello.Name)
+	var hello *SSHello
+	switch m := msg.msg.(type) {
+	case *SSHello:
+		hello = m
+	case *SSError:
+		log.Printf("[%s] new link {%s} closed by remote: %s", n.Me.Name, msg.link.name, m.Reason)
+		msg.link.Silence = true
 		msg.link.Close()
 		return
+	default:
+		n.rejectLink(msg.link, nil, HandshakeError{msg.link.name, "expected hello"})
+		return
+	}
+
+	// Both sides switch protocols as soon as they have each other's hello,
+	// even if the link is about to be rejected, so that the reason can be
+	// read by the remote server.
+	capabilities := n.negotiateCapabilities(hello)
+	msg.link.Upgrade(linkProtocol(capabilities))
+
+	err := n.verifyLink(nl, hello.Name)
+	if err != nil {
+		n.rejectLink(msg.link, hello, err)
+		return
+	}
+	protocol, err := n.negotiateProtocol(hello)
+	if err != nil {
+		n.rejectLink(msg.link, hello, err)
+		return
+	}
+	err = n.verifyHello(hello)
+	if err != nil {
+		n.rejectLink(msg.link, hello, err)
+		return
 	}
 
 	msg.link.SetName(fmt.Sprintf("%s <-> %s", n.Me.Name, hello.Name))
 
 	server := NewLocalServer(hello.Name, hello.Description, msg.link, n.Me)
+	server.Protocol = protocol
+	server.Capabilities = capabilities
 	log.Printf("[%s] got new local server %s", n.Me.Name, hello.Name)
 	n.BurstTo(server)
 	n.SendAll(server.Serialize())
 	log.Printf("[%s] bursted %s", n.Me.Name, hello.Name)
 
+	// Keepalives start one interval after linking.
+	server.pingLast = time.Now()
 	n.Local[msg.link] = server
+	n.scheduleLinkCheck()
 	n.Network[server.Name] = server
 	n.Me.Links[server.Name] = server
 
 	n.Handler.OnServerLink(server, n.Me)
 }
 
+// Check that a hello comes from a server which may join this network.
+func (n *Node) verifyHello(hello *SSHello) error {
+	// Servers which predate version negotiation don't send their network name.
+	if hello.Protocol >= 2 && hello.NetName != n.config.NetName {
+		return HandshakeError{hello.Name, fmt.Sprintf("network %q, expected %q", hello.NetName, n.config.NetName)}
+	}
+	if hello.DefaultSubnet != n.DefaultSubnet.Name {
+		return HandshakeError{hello.Name, fmt.Sprintf("default subnet %q, expected %q", hello.DefaultSubnet, n.DefaultSubnet.Name)}
+	}
+	if _, found := n.Network[hello.Name]; found {
+		return HandshakeError{hello.Name, "server already exists on the network"}
+	}
+	return nil
+}
+
 func (n *Node) handleChannel(msg *SSChannel, from *Server) {
-	// Create a channel optimistically. It'll be thrown away if the channel exists locally.
-	subnet, found := n.Subnet[msg.Subnet]
+	subnet, found := n.subnetFrom(msg.Subnet, from)
 	if !found {
-		log.Fatalf("[%s] on channel message [%s] unknown subnet: %s", n.Me.Name, msg.String(), msg.Subnet)
+		n.protocolViolation(from, fmt.Sprintf("channel %s in unknown subnet %s", msg.Name, msg.Subnet))
+		return
+	}
+	for _, entry := range msg.Lists {
+		if !entry.List.IsValid() {
+			n.protocolViolation(from, fmt.Sprintf("channel %s with unknown list mode %d", msg.Name, entry.List))
+			return
+		}
 	}
 	// Create a channel optimistically. It'll be thrown away if the channel exists locally.
 	channel := NewChannel(n, subnet, msg.Name)
@@ -101,17 +183,45 @@ func (n *Node) handleChannel(msg *SSChannel, from *Server) {
 		}
 		channel = existing
 	} else {
+		channel.Mode = msg.Modes()
+		channel.adoptTopic(msg)
+		channel.mergeLists(msg.Lists, false)
 		subnet.Channel[channel.Lname] = channel
 	}
 
+	// The older side's modes, lists and topic win. Equal timestamps merge
+	// modes and lists, and keep the most recently set topic.
+	modeDelta := ChannelModeDelta{}
+	topicChanged := false
+	if found {
+		oldModes := channel.Mode
+		var lists []ListModeDelta
+		if !trustLocal {
+			channel.Ts = msg.Ts
+			channel.Mode = msg.Modes()
+			lists = channel.mergeLists(msg.Lists, true)
+			topicChanged = channel.adoptTopic(msg)
+		} else if trustRemote {
+			channel.Mode = mergeChannelModes(channel.Mode, msg.Modes())
+			lists = channel.mergeLists(msg.Lists, false)
+			if channel.preferTopic(msg) {
+				topicChanged = channel.adoptTopic(msg)
+			}
+		}
+		modeDelta = oldModes.DeltaTo(channel.Mode)
+		if len(lists) > 0 {
+			modeDelta.Lists = lists
+		}
+	}
+
 	// Keep a running list of member mode deltas to notify the handler later.
 	deltas := make([]MemberModeDelta, 0)
 
 	if !trustLocal {
 		// Can't trust any of the local modes. Go through the existing channel and track all de-modes.
-		for _, mship := range channel.Member {
+		for client, mship := range channel.Member {
 			if mship.IsOwner || mship.IsAdmin || mship.IsOp || mship.IsHalfop || mship.IsVoice {
-				delta := MemberModeDelta{}
+				delta := MemberModeDelta{Client: client}
 				if mship.IsOwner {
 					delta.IsOwner = MODE_REMOVED
 					mship.IsOwner = false
@@ -138,50 +248,71 @@ func (n *Node) handleChannel(msg *SSChannel, from *Server) {
 	}
 
 	// Process new members.
-	log.Printf("Processing new members...")
+	joined := make([]*Client, 0, len(msg.Members))
 	for _, memMsg := range msg.Members {
 		// Get the client by id. If it's not found, just ignore it.
 		client, found := n.lookupClientById(memMsg.Client)
 		if !found {
 			continue
 		}
-		mship := &Membership{
-			Ts: memMsg.Ts,
-		}
-		if trustRemote && (memMsg.IsOwner || memMsg.IsAdmin || memMsg.IsOp || memMsg.IsHalfop || memMsg.IsVoice) {
-			delta := MemberModeDelta{
-				Client: client,
-			}
-			if memMsg.IsOwner {
-				mship.IsOwner = true
-				delta.IsOwner = MODE_ADDED
-			}
-			if memMsg.IsAdmin {
-				mship.IsAdmin = true
-				delta.IsAdmin = MODE_ADDED
-			}
-			if memMsg.IsOp {
-				mship.IsOp = true
-				delta.IsOp = MODE_ADDED
-			}
-			if memMsg.IsHalfop {
-				mship.IsHalfop = true
-				delta.IsHalfop = MODE_ADDED
-			}
-			if memMsg.IsVoice {
-				mship.IsVoice = true
-				delta.IsVoice = MODE_ADDED
+		mship, found := channel.Member[client]
+		if !found {
+			mship = &Membership{
+				Ts: memMsg.Ts,
 			}
+			channel.Member[client] = mship
+			client.Member[channel] = mship
+			joined = append(joined, client)
+		}
+		if !trustRemote {
+			continue
+		}
+		delta := MemberModeDelta{
+			Client: client,
+		}
+		changed := false
+		if memMsg.IsOwner && !mship.IsOwner {
+			mship.IsOwner = true
+			delta.IsOwner = MODE_ADDED
+			changed = true
+		}
+		if memMsg.IsAdmin && !mship.IsAdmin {
+			mship.IsAdmin = true
+			delta.IsAdmin = MODE_ADDED
+			changed = true
+		}
+		if memMsg.IsOp && !mship.IsOp {
+			mship.IsOp = true
+			delta.IsOp = MODE_ADDED
+			changed = true
+		}
+		if memMsg.IsHalfop && !mship.IsHalfop {
+			mship.IsHalfop = true
+			delta.IsHalfop = MODE_ADDED
+			changed = true
+		}
+		if memMsg.IsVoice && !mship.IsVoice {
+			mship.IsVoice = true
+			delta.IsVoice = MODE_ADDED
+			changed = true
+		}
+		if changed {
 			deltas = append(deltas, delta)
 		}
-		channel.Member[client] = mship
 	}
 
 	n.SendAllSkip(msg, from)
 
+	for _, client := range joined {
+		n.Handler.OnChannelJoin(channel, client, channel.Member[client])
+	}
+
 	// Notify mode changes, if any.
-	if len(deltas) > 0 {
-		n.Handler.OnChannelModeChange(channel, nil, ChannelModeDelta{}, deltas)
+	if !modeDelta.IsEmpty() || len(deltas) > 0 {
+		n.Handler.OnChannelModeChange(channel, nil, modeDelta, deltas)
+	}
+	if topicChanged {
+		n.Handler.OnChannelTopic(channel, nil, channel.Topic)
 	}
 }
 
@@ -285,48 +416,29 @@ func (n *Node) handleClient(msg *SSClient, from *Server) {
 	}
 	server, found := n.Network[msg.Server]
 	if !found {
-		log.Fatalf("[%s] on client message [%s] unknown server: %s", n.Me.Name, msg.String(), msg.Server)
+		n.protocolViolation(from, fmt.Sprintf("client %s on unknown server %s", msg.Nick, msg.Server))
+		return
+	}
+	if server.Route != from {
+		n.protocolViolation(from, fmt.Sprintf("client %s on server %s from wrong direction", msg.Nick, msg.Server))
+		return
 	}
 	client.Server = server
 
-	subnet, found := n.Subnet[msg.Subnet]
+	subnet, found := n.subnetFrom(msg.Subnet, from)
 	if !found {
-		log.Fatalf("[%s] on client message [%s] unknown subnet: %s", n.Me.Name, msg.String(), msg.Subnet)
+		n.protocolViolation(from, fmt.Sprintf("client %s in unknown subnet %s", msg.Nick, msg.Subnet))
+		return
 	}
 	client.Subnet = subnet
 
 	existing, found := subnet.Client[client.Lnick]
-	if found {
-		// Collision! Either one client is younger and must die, or they are the same
-		// age exactly, and must both die.
-
-		if !existing.Ts.Before(client.Ts) {
-			kill := &SSKill{
-				Id:         existing.Id(),
-				Server:     n.Me.Name,
-				Authority:  false,
-				Reason:     "Nickname collision (older)",
-				ReasonCode: SS_KILL_REASON_COLLISION,
-			}
-			if existing.Server == n.Me {
-				kill.Authority = true
-				n.processQuit(existing, kill.Reason)
-				n.SendAllSkip(kill, from)
-			} else {
-				existing.Server.Send(kill)
-			}
-		}
-
-		// Skip propagation if the incoming client shouldn't survive
-		// either.
-		//
+	if found && !n.resolveCollision(existing, client.Ts, from) {
 		// When this happens, we could still receive messages concerning this client.
 		// They should be discarded since the Id of the client will be incorrect (wrong
 		// server).
-		if !client.Ts.Before(existing.Ts) {
-			log.Printf("[%s] not adding client [%s] - collision, too young", n.Me.Name, client.DebugString())
-			return
-		}
+		log.Printf("[%s] not adding client [%s] - collision, too young", n.Me.Name, client.DebugString())
+		return
 	}
 
 	subnet.Client[client.Lnick] = client
@@ -335,14 +447,198 @@ func (n *Node) handleClient(msg *SSClient, from *Server) {
 	n.SendAllSkip(msg, from)
 }
 
+// Resolve a collision between an existing client and one arriving from a
+// server with the given timestamp. Either one client is younger and must die,
+// or they are the same age exactly, and must both die. Returns whether the
+// arriving client survives.
+func (n *Node) resolveCollision(existing *Client, ts time.Time, from *Server) bool {
+	if !existing.Ts.Before(ts) {
+		kill := &SSKill{
+			Id:         existing.Id(),
+			Server:     n.Me.Name,
+			Authority:  false,
+			Reason:     "Nickname collision (older)",
+			ReasonCode: SS_KILL_REASON_COLLISION,
+		}
+		if existing.Server == n.Me {
+			kill.Authority = true
+			n.processQuit(existing, kill.Reason, kill.ReasonCode)
+			n.SendAllSkip(kill, from)
+		} else {
+			existing.Server.Send(kill)
+		}
+	}
+	return ts.Before(existing.Ts)
+}
+
+func (n *Node) handleNick(msg *SSNick, from *Server) {
+	client, found := n.lookupClientById(msg.Id)
+	if !found {
+		// Killed in a collision, either here or on its way.
+		log.Printf("[%s] nick change of unknown client %s", n.Me.Name, msg.Id)
+		return
+	}
+	oldNick := client.Nick
+	if n.processRename(msg, CAP_NICK, client, client.Subnet, msg.Nick, msg.Ts, from) {
+		n.Handler.OnNickChange(client, oldNick)
+	}
+}
+
+func (n *Node) handleMove(msg *SSMove, from *Server) {
+	client, found := n.lookupClientById(msg.Id)
+	if !found {
+		log.Printf("[%s] move of unknown client %s", n.Me.Name, msg.Id)
+		return
+	}
+	subnet, found := n.subnetFrom(msg.Subnet, from)
+	if !found {
+		n.protocolViolation(from, fmt.Sprintf("move of %s to unknown subnet %s", msg.Id, msg.Subnet))
+		return
+	}
+	oldSubnet := client.Subnet
+	oldNick := client.Nick
+	if n.processRename(msg, CAP_MOVE, client, subnet, msg.Nick, msg.Ts, from) {
+		n.Handler.OnClientMove(client, oldSubnet, oldNick)
+	}
+}
+
+// Apply a nick change or move of a remote client and pass it on. Returns
+// false if the client was killed instead, having lost a collision.
+func (n *Node) processRename(msg SSMessage, capability string, client *Client, subnet *Subnet, nick string, ts time.Time, from *Server) bool {
+	oldId := client.Id()
+	if client.Server.Route != from {
+		n.protocolViolation(from, fmt.Sprintf("rename of %s from wrong direction", oldId))
+		return false
+	}
+
+	existing, found := subnet.Client[strings.ToLower(nick)]
+	if found && existing != client && !n.resolveCollision(existing, ts, from) {
+		// The renamed client dies too. Servers past this one never learn of
+		// the new nick, so it is killed under its old one.
+		log.Printf("[%s] killing client [%s] - rename collision, too young", n.Me.Name, client.DebugString())
+		n.processQuit(client, "Nickname collision (younger)", SS_KILL_REASON_COLLISION)
+		n.SendAllSkip(&SSKill{
+			Id:         oldId,
+			Server:     n.Me.Name,
+			Authority:  true,
+			Reason:     "Nickname collision (younger)",
+			ReasonCode: SS_KILL_REASON_COLLISION,
+		}, from)
+		return false
+	}
+
+	n.renameClient(client, subnet, nick, ts)
+	log.Printf("[%s] renamed client %s", n.Me.Name, client.DebugString())
+
+	n.sendRename(msg, capability, oldId, client, from)
+	return true
+}
+
+// The subnet named in a message from a server. Servers linked without
+// CAP_SUBNET never announce subnets, so any subnet they name is taken to
+// exist, with no metadata until a server which knows it says otherwise.
+func (n *Node) subnetFrom(name string, from *Server) (*Subnet, bool) {
+	subnet, found := n.Subnet[name]
+	if !found && !from.HasCapability(CAP_SUBNET) {
+		subnet = NewSubnet(name)
+		n.Subnet[name] = subnet
+		found = true
+	}
+	return subnet, found
+}
+
+func (n *Node) handleSubnet(msg *SSSubnet, from *Server) {
+	subnet, found := n.Subnet[msg.Name]
+	updated := false
+	switch {
+	case !found:
+		subnet = NewSubnet(msg.Name)
+		n.Subnet[msg.Name] = subnet
+		fallthrough
+	case !msg.Ts.IsZero() && (subnet.Ts.IsZero() || msg.Ts.Before(subnet.Ts)):
+		// New, or created on both sides of a split and the remote side's
+		// is older, so its metadata wins. A subnet only known through a
+		// server without CAP_SUBNET has no metadata to keep.
+		subnet.DisplayName = msg.DisplayName
+		subnet.Owner = msg.Owner
+		subnet.Ts = msg.Ts
+		updated = true
+	}
+	// The policy goes by when it was set, not by the subnet's age.
+	if subnet.mergePolicy(msg.ForeignJoin, msg.PolicyTs) {
+		updated = true
+	}
+	if !updated {
+		return
+	}
+	log.Printf("[%s] updated subnet %s", n.Me.Name, subnet.DebugString())
+
+	n.SendAllCapableSkip(msg, CAP_SUBNET, from)
+}
+
+func (n *Node) handleSubnetPolicy(msg *SSSubnetPolicy, from *Server) {
+	subnet, found := n.Subnet[msg.Name]
+	if !found {
+		log.Printf("[%s] policy of unknown subnet %s", n.Me.Name, msg.Name)
+		return
+	}
+	// A change which crossed a newer one on the way here loses to it, and
+	// goes no further.
+	if !subnet.mergePolicy(msg.ForeignJoin, msg.Ts) {
+		log.Printf("[%s] dropping stale policy of subnet %s", n.Me.Name, msg.Name)
+		return
+	}
+
+	n.SendAllCapableSkip(msg, CAP_SUBNET, from)
+}
+
+func (n *Node) handleSubnetEnd(msg *SSSubnetEnd, from *Server) {
+	subnet, found := n.Subnet[msg.Name]
+	if !found {
+		return
+	}
+	if subnet == n.DefaultSubnet {
+		n.protocolViolation(from, "deletion of the default subnet")
+		return
+	}
+	origin, found := n.Network[msg.Server]
+	if !found || origin.Route != from {
+		n.protocolViolation(from, fmt.Sprintf("deletion of subnet %s from wrong direction", msg.Name))
+		return
+	}
+
+	// Anything which entered the subnet while the deletion was on its way
+	// goes with it. Clients of the subnet quit, and clients of other subnets
+	// part its channels.
+	for _, client := range subnet.Client {
+		n.processQuit(client, "Subnet deleted", SS_KILL_REASON_SUBNET_DELETED)
+	}
+	for _, channel := range subnet.Channel {
+		for client, _ := range channel.Member {
+			n.Handler.OnChannelPart(channel, client, "Subnet deleted")
+			n.removeMember(channel, client)
+		}
+	}
+	delete(n.Subnet, subnet.Name)
+	log.Printf("[%s] deleted subnet %s", n.Me.Name, subnet.Name)
+
+	n.SendAllCapableSkip(msg, CAP_SUBNET, from)
+}
+
 func (n *Node) handleServer(msg *SSServer, from *Server) {
 	_, found := n.Network[msg.Name]
 	if found {
-		log.Fatalf("[%s] already linked: %s", n.Me.Name, msg.Name)
+		n.protocolViolation(from, fmt.Sprintf("server %s already exists", msg.Name))
+		return
 	}
 	via, found := n.Network[msg.Via]
 	if !found {
-		log.Fatalf("[%s] %s via %s but that doesn't exist", n.Me.Name, msg.Name, msg.Via)
+		n.protocolViolation(from, fmt.Sprintf("server %s via unknown server %s", msg.Name, msg.Via))
+		return
+	}
+	if via.Route != from {
+		n.protocolViolation(from, fmt.Sprintf("server %s via %s from wrong direction", msg.Name, msg.Via))
+		return
 	}
 
 	server := NewRemoteServer(msg.Name, msg.Desc, via)
@@ -372,10 +668,12 @@ func (n *Node) handleSync(msg *SSSync, from *Server) {
 	} else {
 		origin, found := n.Network[msg.Origin]
 		if !found {
-			log.Fatalf("[sync] unknown origin: %s", msg.Origin)
+			n.protocolViolation(from, fmt.Sprintf("sync reply to unknown origin %s", msg.Origin))
+			return
 		}
 		if origin.Route == from {
-			log.Fatalf("[sync] loop detected: %s", msg.Origin)
+			n.protocolViolation(from, fmt.Sprintf("sync reply to %s loops back", msg.Origin))
+			return
 		}
 
 		if origin != n.Me {
@@ -385,7 +683,8 @@ func (n *Node) handleSync(msg *SSSync, from *Server) {
 
 		sr, found := n.syncsActive[msg.Sequence]
 		if !found {
-			log.Fatalf("[sync] unknown sequence: %d", msg.Sequence)
+			n.protocolViolation(from, fmt.Sprintf("sync reply with unknown sequence %d", msg.Sequence))
+			return
 		}
 		sr.servers[msg.ReplyFrom] = true
 
@@ -405,9 +704,8 @@ func (n *Node) handleKill(msg *SSKill, from *Server) {
 	client, found := n.lookupClientById(msg.Id)
 	if msg.Authority {
 		// This is a kill order!
-		client, found := n.lookupClientById(msg.Id)
 		if found {
-			n.processQuit(client, msg.Reason)
+			n.processKill(client, msg)
 		}
 
 		n.SendAllSkip(msg, from)
@@ -418,6 +716,7 @@ func (n *Node) handleKill(msg *SSKill, from *Server) {
 		if client.Server == n.Me {
 			// Instruction to kill the client.
 			msg.Authority = true
+			n.processKill(client, msg)
 			n.SendAll(msg)
 		} else if client.Server.Route != from {
 			client.Server.Send(msg)
@@ -428,10 +727,12 @@ func (n *Node) handleKill(msg *SSKill, from *Server) {
 func (n *Node) handleSplit(msg *SSSplit, from *Server) {
 	server, found := n.Network[msg.Server]
 	if !found {
-		log.Fatalf("[%s] split of %s but not connected", n.Me.Name, msg.Server)
+		log.Printf("[%s] split of %s but not connected", n.Me.Name, msg.Server)
+		return
 	}
-	if server.Route != from {
-		log.Fatalf("[%s] split of server %s from wrong direction!", n.Me.Name, msg.Server)
+	if server == from || server.Route != from {
+		n.protocolViolation(from, fmt.Sprintf("split of server %s from wrong direction", msg.Server))
+		return
 	}
 
 	n.processSplit(server, msg.Reason)
@@ -439,6 +740,38 @@ func (n *Node) handleSplit(msg *SSSplit, from *Server) {
 	n.bumpVersion()
 }
 
+func (n *Node) handleKick(msg *SSKick, from *Server) {
+	channel, found := n.lookupChannelById(msg.Channel)
+	if !found {
+		return
+	}
+	target, found := n.lookupClientById(msg.Target)
+	if !found {
+		return
+	}
+	mship, found := channel.Member[target]
+	if !found {
+		return
+	}
+	if mship.Ts.After(msg.Ts) {
+		// The target rejoined after the kick was made, and stays.
+		return
+	}
+	if channel.Ts.Before(msg.ChannelTs) {
+		// The kicker's side lost the channel to this older one, and with it
+		// any right to kick. Put the target back on that side.
+		from.Send(mship.Serialize(channel, target))
+		return
+	}
+
+	// The kicker may have quit since. The kick still stands.
+	by, _ := n.lookupClientById(msg.From)
+
+	n.Handler.OnChannelKick(channel, by, target, msg.Reason)
+	n.removeMember(channel, target)
+	n.sendKick(msg, from)
+}
+
 func (n *Node) handlePrivateMessage(msg *SSPrivateMessage, from *Server) {
 	to, found := n.lookupClientById(msg.To)
 	if !found {
@@ -455,14 +788,47 @@ func (n *Node) handlePrivateMessage(msg *SSPrivateMessage, from *Server) {
 		n.Handler.OnPrivateMessage(from, to, msg.Message)
 	} else {
 		if to.Server.Route == from {
-			// TODO disconnect server for being stupid
-			log.Printf("Loop!")
+			n.protocolViolation(from, fmt.Sprintf("PM to %s from wrong direction", msg.To))
 			return
 		}
 		to.Server.Route.Send(msg)
 	}
 }
 
+func (n *Node) handleInvite(msg *SSInvite, from *Server) {
+	to, found := n.lookupClientById(msg.To)
+	if !found {
+		log.Printf("Invite of unknown user: %s", msg.To)
+		return
+	}
+
+	if to.IsLocal() {
+		inviter, found := n.lookupClientById(msg.From)
+		if !found {
+			log.Printf("Invite from unknown user: %s", msg.From)
+			return
+		}
+		channel, found := n.lookupChannelById(msg.Channel)
+		if !found {
+			log.Printf("Invite to unknown channel: %s", msg.Channel)
+			return
+		}
+		if _, found := channel.Member[to]; found {
+			return
+		}
+		channel.Invites[to] = struct{}{}
+		n.Handler.OnInvite(inviter, to, channel)
+	} else {
+		if to.Server.Route == from {
+			n.protocolViolation(from, fmt.Sprintf("invite of %s from wrong direction", msg.To))
+			return
+		}
+		if to.Server.Route.HasCapability(CAP_INVITE) {
+			to.Server.Route.Send(msg)
+		}
+	}
+}
+
 func (n *Node) handleChannelMessage(msg *SSChannelMessage, from *Server) {
 	to, found := n.lookupChannelById(msg.To)
 	if !found {
@@ -475,17 +841,54 @@ func (n *Node) handleChannelMessage(msg *SSChannelMessage, from *Server) {
 		log.Printf("CM from unknown user: %s", msg.From)
 		return
 	}
+	if n.config.CheckRemoteChannelMessages {
+		if err := n.CanSendToChannel(fromClient, to); err != nil {
+			log.Printf("[%s] dropping CM from %s to %s: %v", n.Me.Name, msg.From, msg.To, err)
+			return
+		}
+	}
 
 	n.Handler.OnChannelMessage(fromClient, to, msg.Message)
 	n.SendAllSkip(msg, from)
 }
 
+func (n *Node) handleTopic(msg *SSTopic, from *Server) {
+	channel, found := n.lookupChannelById(msg.Channel)
+	if !found {
+		log.Printf("Topic change on unknown channel: %s", msg.Channel)
+		return
+	}
+
+	// A change which crossed a newer one on the way here loses to it, and
+	// goes no further.
+	if !channel.newerTopic(msg.Topic, msg.Ts) {
+		log.Printf("Dropping stale topic change on %s", msg.Channel)
+		return
+	}
+
+	// The setter may have quit since. The topic still stands.
+	actor, _ := n.lookupClientById(msg.From)
+
+	channel.Topic = msg.Topic
+	channel.TopicTs = msg.Ts
+	channel.TopicBy = msg.By
+
+	n.Handler.OnChannelTopic(channel, actor, msg.Topic)
+	n.SendAllCapableSkip(msg, CAP_TOPIC, from)
+}
+
 func (n *Node) handleChannelMode(msg *SSChannelMode, from *Server) {
 	target, found := n.lookupChannelById(msg.Channel)
 	if !found {
 		log.Printf("ChannelMode change on unknown channel: %s", msg.Channel)
 		return
 	}
+	for _, delta := range msg.Mode.Lists {
+		if !delta.List.IsValid() {
+			n.protocolViolation(from, fmt.Sprintf("mode change on %s with unknown list mode %d", msg.Channel, delta.List))
+			return
+		}
+	}
 
 	actor, found := n.lookupClientById(msg.From)
 	if !found {
@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Ping every local server which supports keepalives, has answered its last
+// ping and was last pinged at least the ping interval ago, and split any
+// server whose ping has gone unanswered for longer than the ping timeout. New
+// links which haven't said hello within the handshake timeout are closed.
+func (n *Node) checkLinks(now time.Time) {
+	for link, nl := range n.NewLinks {
+		elapsed := now.Sub(nl.started)
+		if elapsed >= n.config.HandshakeTimeout {
+			delete(n.NewLinks, link)
+			n.rejectLink(link, nil, HandshakeError{link.name, fmt.Sprintf("no hello within %v", elapsed)})
+			n.handshakeDone(link)
+		}
+	}
+	for link, server := range n.Local {
+		if !server.HasCapability(CAP_KEEPALIVE) {
+			continue
+		}
+		if !server.pingSent.IsZero() {
+			elapsed := now.Sub(server.pingSent)
+			if elapsed >= n.config.PingTimeout {
+				n.split(link, PingTimeoutError{server.Name, elapsed})
+			}
+			continue
+		}
+		if now.Sub(server.pingLast) < n.config.PingInterval {
+			continue
+		}
+		server.pingCookie++
+		server.pingSent = now
+		server.pingLast = now
+		server.Send(&SSPing{server.pingCookie})
+	}
+	n.scheduleLinkCheck()
+}
+
+// Arrange for checkLinks to run at the earliest handshake timeout, ping or
+// ping timeout of any link.
+func (n *Node) scheduleLinkCheck() {
+	var next time.Time
+	due := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	for _, nl := range n.NewLinks {
+		due(nl.started.Add(n.config.HandshakeTimeout))
+	}
+	for _, server := range n.Local {
+		if !server.HasCapability(CAP_KEEPALIVE) {
+			continue
+		}
+		if !server.pingSent.IsZero() {
+			due(server.pingSent.Add(n.config.PingTimeout))
+		} else {
+			due(server.pingLast.Add(n.config.PingInterval))
+		}
+	}
+	n.linkTimer.Stop()
+	if !next.IsZero() {
+		n.linkTimer.Reset(time.Until(next))
+	}
+}
+
+func (n *Node) handlePing(msg *SSPing, from *Server) {
+	from.Send(&SSPong{msg.Cookie})
+}
+
+func (n *Node) handlePong(msg *SSPong, from *Server) {
+	if from.pingSent.IsZero() || msg.Cookie != from.pingCookie {
+		log.Printf("[%s] unexpected pong(%d) from %s", n.Me.Name, msg.Cookie, from.Name)
+		return
+	}
+	from.Lag = time.Since(from.pingSent)
+	from.pingSent = time.Time{}
+	n.scheduleLinkCheck()
+}
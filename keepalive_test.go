@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newKeepaliveTestNode(name string, interval, timeout time.Duration, wg *sync.WaitGroup) *Node {
+	config := testConfig(name)
+	config.PingInterval = interval
+	config.PingTimeout = timeout
+	return NewNode(config, nil, wg)
+}
+
+// Wait for a condition, evaluated on the node goroutine, to become true.
+func waitForNode(t *testing.T, node *Node, what string, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		ch := make(chan bool)
+		node.Do(func() {
+			ch <- cond()
+		})
+		if <-ch {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%s: timed out waiting for %s", node.Me.Name, what)
+}
+
+func TestKeepalive_Lag(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	a := newKeepaliveTestNode("hub.a", 10*time.Millisecond, time.Second, wg)
+	b := newKeepaliveTestNode("hub.b", 10*time.Millisecond, time.Second, wg)
+
+	abr, abw := io.Pipe()
+	bar, baw := io.Pipe()
+	a.Do(func() {
+		a.BeginLink(bar, abw, nil, "a -> b")
+	})
+	b.Do(func() {
+		b.BeginLink(abr, baw, nil, "b -> a")
+	})
+
+	waitForNode(t, a, "lag of hub.b", func() bool {
+		server, found := a.Network["hub.b"]
+		return found && server.Lag > 0
+	})
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
+
+func TestKeepalive_Timeout(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	a := newKeepaliveTestNode("hub.a", 10*time.Millisecond, 30*time.Millisecond, wg)
+
+	// A peer which says hello, then never answers anything.
+	abr, abw := io.Pipe()
+	bar, baw := io.Pipe()
+	go io.Copy(io.Discard, abr)
+	a.Do(func() {
+		a.BeginLink(bar, abw, nil, "a -> b")
+	})
+	NewGobServerProtocolWriter(baw).WriteMessage(SSHello{
+		Protocol:      PROTOCOL_VERSION,
+		Name:          "hub.b",
+		Description:   "Silent",
+		DefaultSubnet: "test",
+		Capabilities:  []string{CAP_KEEPALIVE},
+		NetName:       "TestNet",
+	})
+
+	waitForNode(t, a, "hub.b to link", func() bool {
+		_, found := a.Network["hub.b"]
+		return found
+	})
+	waitForNode(t, a, "hub.b to time out", func() bool {
+		_, found := a.Network["hub.b"]
+		return !found
+	})
+
+	baw.Close()
+	a.Shutdown()
+	wg.Wait()
+}
+
+func TestKeepalive_Interval(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	// A short handshake timeout mustn't make pings more frequent.
+	config := testConfig("hub.a")
+	config.PingInterval = 150 * time.Millisecond
+	config.HandshakeTimeout = 10 * time.Millisecond
+	a := NewNode(config, nil, wg)
+	b := newKeepaliveTestNode("hub.b", 150*time.Millisecond, time.Second, wg)
+
+	abr, abw := io.Pipe()
+	bar, baw := io.Pipe()
+	a.Do(func() {
+		a.BeginLink(bar, abw, nil, "a -> b")
+	})
+	b.Do(func() {
+		b.BeginLink(abr, baw, nil, "b -> a")
+	})
+	waitForNode(t, a, "hub.b to link", func() bool {
+		_, found := a.Network["hub.b"]
+		return found
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	ch := make(chan uint64)
+	a.Do(func() {
+		ch <- a.Network["hub.b"].pingCookie
+	})
+	if pings := <-ch; pings != 0 {
+		t.Errorf("Expected no pings within the ping interval, got %d", pings)
+	}
+
+	waitForNode(t, a, "lag of hub.b", func() bool {
+		return a.Network["hub.b"].Lag > 0
+	})
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
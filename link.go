@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"sync"
@@ -10,10 +11,15 @@ import (
 type Link struct {
 	name                string
 	readChan, writeChan io.Closer
+	buffer              *bufio.Reader
 	reader              ServerProtocolReader
 	writer              ServerProtocolWriter
 	sq                  *SendQ
 
+	// If set, reading pauses after a hello until the protocol for the rest
+	// of the link is chosen by Upgrade().
+	upgrade chan ServerProtocolFactory
+
 	recv  chan<- LinkMessage
 	trans chan LinkMessage
 	exit  chan bool
@@ -21,10 +27,28 @@ type Link struct {
 	// Ensures the link can only be closed once.
 	closed bool
 
+	// Whether messages have been written since the last Flush().
+	dirty bool
+
+	// The first error writing to the link. Nothing more is written once set,
+	// and the node splits the link after handling the current event.
+	failed error
+
 	Silence bool
 }
 
 func NewLink(reader io.ReadCloser, writer io.WriteCloser, sendBufferSize int, protoFactory ServerProtocolFactory, recv chan<- LinkMessage, wg *sync.WaitGroup) *Link {
+	return makeLink(reader, writer, sendBufferSize, protoFactory, recv, wg, false)
+}
+
+// Construct a Link whose protocol may change once the hellos have been
+// exchanged. After reading a hello, the link reads nothing further until
+// Upgrade() is called.
+func NewNegotiatedLink(reader io.ReadCloser, writer io.WriteCloser, sendBufferSize int, protoFactory ServerProtocolFactory, recv chan<- LinkMessage, wg *sync.WaitGroup) *Link {
+	return makeLink(reader, writer, sendBufferSize, protoFactory, recv, wg, true)
+}
+
+func makeLink(reader io.ReadCloser, writer io.WriteCloser, sendBufferSize int, protoFactory ServerProtocolFactory, recv chan<- LinkMessage, wg *sync.WaitGroup, negotiated bool) *Link {
 	l := &Link{
 		name:      "unnamed",
 		readChan:  reader,
@@ -33,8 +57,15 @@ func NewLink(reader io.ReadCloser, writer io.WriteCloser, sendBufferSize int, pr
 		trans:     make(chan LinkMessage, 1),
 		exit:      make(chan bool, 1),
 	}
+	if negotiated {
+		l.upgrade = make(chan ServerProtocolFactory, 1)
+	}
+	// Protocol readers are given a buffered reader, which they never read
+	// beyond the end of the current message. This allows the protocol to be
+	// swapped without losing data.
+	l.buffer = bufio.NewReader(reader)
 	l.sq = NewSendQ(writer, sendBufferSize, wg)
-	l.reader = protoFactory.Reader(reader)
+	l.reader = protoFactory.Reader(l.buffer)
 	l.writer = protoFactory.Writer(l.sq)
 	wg.Add(2)
 	go l.readLoop(wg)
@@ -48,7 +79,45 @@ func (l *Link) SetName(name string) {
 }
 
 func (l *Link) WriteMessage(msg SSMessage) error {
-	return l.writer.WriteMessage(msg)
+	if l.failed != nil {
+		return l.failed
+	}
+	l.dirty = true
+	err := l.writer.WriteMessage(msg)
+	if err != nil {
+		l.failed = err
+	}
+	return err
+}
+
+// Implemented by protocol writers which hold messages back until flushed.
+type flushingServerProtocolWriter interface {
+	Flush() error
+}
+
+// Push any messages held back by the protocol out to the SendQ. Like
+// WriteMessage, only called on the node goroutine.
+func (l *Link) Flush() error {
+	if !l.dirty || l.failed != nil {
+		return l.failed
+	}
+	l.dirty = false
+	if fw, ok := l.writer.(flushingServerProtocolWriter); ok {
+		l.failed = fw.Flush()
+	}
+	return l.failed
+}
+
+// Switch the link to a new protocol after the hellos have been exchanged.
+// Messages written from now on use the new protocol, as do messages read after
+// the remote hello. A nil factory keeps the current protocol.
+func (l *Link) Upgrade(protoFactory ServerProtocolFactory) {
+	if protoFactory != nil {
+		l.writer = protoFactory.Writer(l.sq)
+	}
+	if l.upgrade != nil {
+		l.upgrade <- protoFactory
+	}
 }
 
 func (l *Link) Close() {
@@ -61,6 +130,7 @@ func (l *Link) Close() {
 }
 
 func (l *Link) Shutdown() {
+	l.Flush()
 	l.readChan.Close()
 	if !l.closed {
 		l.closed = true
@@ -108,11 +178,26 @@ func (l *Link) readLoop(wg *sync.WaitGroup) {
 			// Attempt to read.
 			msg, err := l.reader.ReadMessage()
 			l.trans <- LinkMessage{l, msg, err}
+			// A corrupt frame doesn't affect the frames which follow it.
+			if _, ok := err.(CorruptFrameError); ok {
+				continue
+			}
 			// Don't attempt to read anymore.
 			if err != nil {
 				close(l.trans)
 				return
 			}
+			if _, ok := msg.(*SSHello); ok && l.upgrade != nil {
+				select {
+				case <-l.exit:
+					close(l.trans)
+					return
+				case protoFactory := <-l.upgrade:
+					if protoFactory != nil {
+						l.reader = protoFactory.Reader(l.buffer)
+					}
+				}
+			}
 		}
 	}
 }
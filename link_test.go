@@ -22,7 +22,7 @@ func TestLinkSingleMessage(t *testing.T) {
 	r, w := io.Pipe()
 	recv := make(chan LinkMessage, 1)
 	l := NewLink(r, w, 1024, GobServerProtocolFactory, recv, wg)
-	hello := SSHello{1, 123, "server.name", "server description", "test"}
+	hello := SSHello{Protocol: 1, LocalTimeMs: 123, Name: "server.name", Description: "server description", DefaultSubnet: "test"}
 	l.WriteMessage(hello)
 	msg := <-recv
 	recvHello, ok := msg.msg.(*SSHello)
@@ -46,7 +46,7 @@ func TestLinkShutdown(t *testing.T) {
 	l1 := NewLink(r1, w2, 1024, GobServerProtocolFactory, recv1, wg)
 	l2 := NewLink(r2, w1, 1024, GobServerProtocolFactory, recv2, wg)
 
-	hello := SSHello{1, 123, "server.name", "server description", "test"}
+	hello := SSHello{Protocol: 1, LocalTimeMs: 123, Name: "server.name", Description: "server description", DefaultSubnet: "test"}
 
 	// Send message 4 times.
 	l1.WriteMessage(hello)
@@ -89,8 +89,8 @@ func TestLinkToLink(t *testing.T) {
 	l1 := NewLink(r1, w2, 1024, GobServerProtocolFactory, recv1, wg)
 	l2 := NewLink(r2, w1, 1024, GobServerProtocolFactory, recv2, wg)
 
-	hello1 := SSHello{1, 123, "server.a", "server description", "test"}
-	hello2 := SSHello{1, 123, "server.b", "server description", "test"}
+	hello1 := SSHello{Protocol: 1, LocalTimeMs: 123, Name: "server.a", Description: "server description", DefaultSubnet: "test"}
+	hello2 := SSHello{Protocol: 1, LocalTimeMs: 123, Name: "server.b", Description: "server description", DefaultSubnet: "test"}
 
 	l1.WriteMessage(hello1)
 	l2.WriteMessage(hello2)
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"strings"
+	"time"
+)
+
+// A channel list mode, holding hostmasks.
+type ListMode uint8
+
+const (
+	LIST_BAN ListMode = iota
+	LIST_EXCEPT
+	LIST_INVITE_EXCEPT
+)
+
+// The most entries a single list of a channel holds. Further additions are
+// ignored.
+const MaxListEntries = 100
+
+// Whether the list mode is one of the above.
+func (l ListMode) IsValid() bool {
+	return l <= LIST_INVITE_EXCEPT
+}
+
+// The mode character of the list.
+func (l ListMode) Char() rune {
+	switch l {
+	case LIST_EXCEPT:
+		return 'e'
+	case LIST_INVITE_EXCEPT:
+		return 'I'
+	default:
+		return 'b'
+	}
+}
+
+type ListEntry struct {
+	Mask  string
+	SetBy string
+	SetTs time.Time
+}
+
+// Entries of a channel list, keyed by lowercased mask.
+type ChannelList map[string]*ListEntry
+
+// Addition or removal of a list entry. SetBy and SetTs are filled in when the
+// change is made, and are only meaningful for additions.
+type ListModeDelta struct {
+	List   ListMode
+	Change ModeDelta
+	Mask   string
+	SetBy  string
+	SetTs  time.Time
+}
+
+// Expand a hostmask as typed by a user into its full nick!ident@host form:
+// "foo" becomes "foo!*@*" and "user@host" becomes "*!user@host".
+func NormalizeMask(mask string) string {
+	nick, rest := "*", mask
+	if idx := strings.Index(mask, "!"); idx >= 0 {
+		nick, rest = mask[:idx], mask[idx+1:]
+	} else if !strings.Contains(mask, "@") {
+		nick, rest = mask, ""
+	}
+	ident, host := "*", rest
+	if idx := strings.Index(rest, "@"); idx >= 0 {
+		ident, host = rest[:idx], rest[idx+1:]
+	} else if rest != "" {
+		ident, host = rest, ""
+	}
+	if nick == "" {
+		nick = "*"
+	}
+	if ident == "" {
+		ident = "*"
+	}
+	if host == "" {
+		host = "*"
+	}
+	return nick + "!" + ident + "@" + host
+}
+
+// Whether a hostmask matches the client's nick and ident with any of its
+// host, vhost or IP.
+func (c *Client) MatchesMask(mask string) bool {
+	mask = strings.ToLower(NormalizeMask(mask))
+	prefix := strings.ToLower(c.Nick + "!" + c.Ident + "@")
+	for _, host := range []string{c.Host, c.Vhost, c.Ip} {
+		if host != "" && matchGlob(mask, prefix+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match a string against a pattern where '*' matches any run of characters and
+// '?' any single character.
+func matchGlob(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func (ch *Channel) list(mode ListMode) ChannelList {
+	switch mode {
+	case LIST_EXCEPT:
+		return ch.Excepts
+	case LIST_INVITE_EXCEPT:
+		return ch.InviteExcepts
+	default:
+		return ch.Bans
+	}
+}
+
+func (l ChannelList) matches(client *Client) bool {
+	for _, entry := range l {
+		if client.MatchesMask(entry.Mask) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the client matches a ban and no exception.
+func (ch *Channel) IsBanned(client *Client) bool {
+	return ch.Bans.matches(client) && !ch.Excepts.matches(client)
+}
+
+// Whether the client may join without an invite.
+func (ch *Channel) IsInviteExcepted(client *Client) bool {
+	return ch.InviteExcepts.matches(client)
+}
+
+// Add or remove a list entry, returning whether anything changed. Nothing is
+// added to a full list.
+func (ch *Channel) applyListDelta(delta ListModeDelta) bool {
+	list := ch.list(delta.List)
+	key := strings.ToLower(delta.Mask)
+	_, found := list[key]
+	switch {
+	case delta.Change == MODE_ADDED && !found && delta.Mask != "" && len(list) < MaxListEntries:
+		list[key] = &ListEntry{delta.Mask, delta.SetBy, delta.SetTs}
+		return true
+	case delta.Change == MODE_REMOVED && found:
+		delete(list, key)
+		return true
+	}
+	return false
+}
+
+// Take the lists from a serialized copy of the channel. Unless replacing
+// them, entries are only added. Returns the changes made.
+func (ch *Channel) mergeLists(entries []SSListEntry, replace bool) []ListModeDelta {
+	changes := make([]ListModeDelta, 0)
+	if replace {
+		remote := make(map[ListMode]map[string]bool)
+		for _, entry := range entries {
+			if remote[entry.List] == nil {
+				remote[entry.List] = make(map[string]bool)
+			}
+			remote[entry.List][strings.ToLower(entry.Mask)] = true
+		}
+		for _, mode := range []ListMode{LIST_BAN, LIST_EXCEPT, LIST_INVITE_EXCEPT} {
+			for key, entry := range ch.list(mode) {
+				if !remote[mode][key] {
+					delete(ch.list(mode), key)
+					changes = append(changes, ListModeDelta{mode, MODE_REMOVED, entry.Mask, entry.SetBy, entry.SetTs})
+				}
+			}
+		}
+	}
+	for _, entry := range entries {
+		delta := ListModeDelta{entry.List, MODE_ADDED, entry.Mask, entry.SetBy, entry.SetTs}
+		if ch.applyListDelta(delta) {
+			changes = append(changes, delta)
+		}
+	}
+	return changes
+}
+
+func (ch *Channel) serializeLists() []SSListEntry {
+	entries := make([]SSListEntry, 0, len(ch.Bans)+len(ch.Excepts)+len(ch.InviteExcepts))
+	for _, mode := range []ListMode{LIST_BAN, LIST_EXCEPT, LIST_INVITE_EXCEPT} {
+		for _, entry := range ch.list(mode) {
+			entries = append(entries, SSListEntry{mode, entry.Mask, entry.SetBy, entry.SetTs})
+		}
+	}
+	return entries
+}
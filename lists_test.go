@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNormalizeMask(t *testing.T) {
+	for mask, expected := range map[string]string{
+		"foo":           "foo!*@*",
+		"foo!bar":       "foo!bar@*",
+		"bar@host":      "*!bar@host",
+		"*@host":        "*!*@host",
+		"foo!bar@host":  "foo!bar@host",
+		"!@":            "*!*@*",
+		"*!*@*.example": "*!*@*.example",
+	} {
+		if normalized := NormalizeMask(mask); normalized != expected {
+			t.Errorf("NormalizeMask(%s): expected '%s', got '%s'", mask, expected, normalized)
+		}
+	}
+}
+
+func TestMatchesMask(t *testing.T) {
+	client := &Client{Nick: "Test", Ident: "ident", Host: "host.example.com", Vhost: "user/test", Ip: "192.0.2.1"}
+	for mask, expected := range map[string]bool{
+		"test":                  true,
+		"TEST!*@*":              true,
+		"*!ident@*.example.com": true,
+		"*!*@user/test":         true,
+		"*@192.0.2.?":           true,
+		"*!*@192.0.2.1?":        false,
+		"other":                 false,
+		"*!other@*":             false,
+		"t*t!i*t@h*m":           true,
+	} {
+		if matched := client.MatchesMask(mask); matched != expected {
+			t.Errorf("MatchesMask(%s): expected %v", mask, expected)
+		}
+	}
+}
+
+func TestApplyListDelta_Full(t *testing.T) {
+	channel := NewChannel(nil, NewSubnet("test"), "test")
+	for i := 0; i < MaxListEntries; i++ {
+		if !channel.applyListDelta(ListModeDelta{List: LIST_BAN, Change: MODE_ADDED, Mask: fmt.Sprintf("*!*@%d.example", i)}) {
+			t.Fatalf("Failed to add ban %d", i)
+		}
+	}
+	if channel.applyListDelta(ListModeDelta{List: LIST_BAN, Change: MODE_ADDED, Mask: "*!*@full.example"}) {
+		t.Errorf("Expected a full ban list to refuse another entry")
+	}
+	if len(channel.Bans) != MaxListEntries {
+		t.Errorf("Expected %d bans, got %d", MaxListEntries, len(channel.Bans))
+	}
+	if !channel.applyListDelta(ListModeDelta{List: LIST_EXCEPT, Change: MODE_ADDED, Mask: "*!*@full.example"}) {
+		t.Errorf("Expected other lists to have room")
+	}
+}
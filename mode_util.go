@@ -2,6 +2,7 @@ package lib
 
 import (
 	"log"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 )
@@ -17,13 +18,14 @@ func ParseChannelModeString(modes string, args []string, resolveClient ResolveCl
 		if r == utf8.RuneError || size == 0 {
 			break
 		}
+		modes = modes[size:]
 		switch r {
 		case '+':
 			operation = MODE_ADDED
 		case '-':
 			operation = MODE_REMOVED
 		case 'q', 'a', 'o', 'h', 'v':
-			if len(args) < 0 {
+			if len(args) == 0 {
 				continue
 			}
 			arg := args[0]
@@ -61,8 +63,55 @@ func ParseChannelModeString(modes string, args []string, resolveClient ResolveCl
 			channel.Secret = operation
 		case 't':
 			channel.TopicProtected = operation
+		case 'i':
+			channel.InviteOnly = operation
+		case 'k':
+			switch operation {
+			case MODE_ADDED:
+				if len(args) == 0 {
+					continue
+				}
+				channel.Key = operation
+				channel.KeyValue = args[0]
+				args = args[1:]
+			case MODE_REMOVED:
+				// The key is usually given when removing it too, but needn't
+				// match.
+				if len(args) > 0 {
+					args = args[1:]
+				}
+				channel.Key = operation
+			}
+		case 'b', 'e', 'I':
+			// Without a mask, this is a request for the list.
+			if len(args) == 0 || operation == MODE_UNCHANGED {
+				continue
+			}
+			list := LIST_BAN
+			if r == 'e' {
+				list = LIST_EXCEPT
+			} else if r == 'I' {
+				list = LIST_INVITE_EXCEPT
+			}
+			channel.Lists = append(channel.Lists, ListModeDelta{List: list, Change: operation, Mask: NormalizeMask(args[0])})
+			args = args[1:]
+		case 'l':
+			switch operation {
+			case MODE_ADDED:
+				if len(args) == 0 {
+					continue
+				}
+				limit, err := strconv.ParseUint(args[0], 10, 32)
+				args = args[1:]
+				if err != nil || limit == 0 {
+					continue
+				}
+				channel.Limit = operation
+				channel.LimitValue = uint32(limit)
+			case MODE_REMOVED:
+				channel.Limit = operation
+			}
 		}
-		modes = modes[size:]
 	}
 	member = make([]MemberModeDelta, 0, len(memberMap))
 	for _, delta := range memberMap {
@@ -105,6 +154,33 @@ func StringifyChannelModes(channel ChannelModeDelta, member []MemberModeDelta, s
 			maybeAddOpChar(operation)
 			modes = append(modes, 't')
 		}
+		if channel.InviteOnly == operation {
+			maybeAddOpChar(operation)
+			modes = append(modes, 'i')
+		}
+		if channel.Limit == operation {
+			maybeAddOpChar(operation)
+			modes = append(modes, 'l')
+			if operation == MODE_ADDED {
+				args = append(args, strconv.FormatUint(uint64(channel.LimitValue), 10))
+			}
+		}
+		if channel.Key == operation {
+			maybeAddOpChar(operation)
+			modes = append(modes, 'k')
+			if operation == MODE_ADDED {
+				args = append(args, channel.KeyValue)
+			} else {
+				args = append(args, "*")
+			}
+		}
+		for _, list := range channel.Lists {
+			if list.Change == operation {
+				maybeAddOpChar(operation)
+				modes = append(modes, list.List.Char())
+				args = append(args, list.Mask)
+			}
+		}
 
 		for _, mode := range member {
 			if mode.IsOwner == operation {
@@ -173,6 +249,11 @@ func FilterChannelModes(channel *Channel, actor *Client, channelMode ChannelMode
 
 		if membership.IsOwner {
 			outDelta = delta
+		} else if membership.IsAdmin {
+			outDelta.IsAdmin = delta.IsAdmin
+			outDelta.IsOp = delta.IsOp
+			outDelta.IsHalfop = delta.IsHalfop
+			outDelta.IsVoice = delta.IsVoice
 		} else if membership.IsOp {
 			outDelta.IsOp = delta.IsOp
 			outDelta.IsHalfop = delta.IsHalfop
@@ -197,6 +278,12 @@ func FilterChannelModes(channel *Channel, actor *Client, channelMode ChannelMode
 		outMode.NoExternalMessages = channelMode.NoExternalMessages
 		outMode.Secret = channelMode.Secret
 		outMode.TopicProtected = channelMode.TopicProtected
+		outMode.InviteOnly = channelMode.InviteOnly
+		outMode.Limit = channelMode.Limit
+		outMode.LimitValue = channelMode.LimitValue
+		outMode.Key = channelMode.Key
+		outMode.KeyValue = channelMode.KeyValue
+		outMode.Lists = channelMode.Lists
 	}
 	return outMode, outMember
 }
@@ -71,3 +71,91 @@ func TestStringifyModes_Negative(t *testing.T) {
 		t.Errorf("Got unexpected mode string '%s'", modeStr)
 	}
 }
+
+func TestParseMode_KeyLimit(t *testing.T) {
+	channel, _ := ParseChannelModeString("+kl-s", []string{"secret", "10"}, nil)
+	if channel.Key != MODE_ADDED || channel.KeyValue != "secret" {
+		t.Errorf("Expected key 'secret' to be added")
+	}
+	if channel.Limit != MODE_ADDED || channel.LimitValue != 10 {
+		t.Errorf("Expected limit 10 to be added")
+	}
+	if channel.Secret != MODE_REMOVED {
+		t.Error("Expected 'secret' to be removed")
+	}
+
+	channel, _ = ParseChannelModeString("-lk", []string{"secret"}, nil)
+	if channel.Key != MODE_REMOVED || channel.Limit != MODE_REMOVED {
+		t.Errorf("Expected key and limit to be removed")
+	}
+
+	// Invalid limits are ignored.
+	channel, _ = ParseChannelModeString("+l", []string{"many"}, nil)
+	if channel.Limit != MODE_UNCHANGED {
+		t.Errorf("Expected limit to be unchanged")
+	}
+}
+
+func TestParseMode_UnknownClient(t *testing.T) {
+	channel, member := ParseChannelModeString("+ovm", []string{"nobody"}, func(name string) (*Client, bool) {
+		return nil, false
+	})
+	if len(member) != 0 {
+		t.Errorf("Expected no member mode changes, but got %d", len(member))
+	}
+	if channel.Moderated != MODE_ADDED {
+		t.Error("Moderated not added")
+	}
+}
+
+func TestStringifyModes_KeyLimit(t *testing.T) {
+	channel := ChannelModeDelta{
+		Key:        MODE_ADDED,
+		KeyValue:   "secret",
+		Limit:      MODE_REMOVED,
+		LimitValue: 10,
+	}
+	modeStr := StringifyChannelModes(channel, []MemberModeDelta{}, nil)
+	if modeStr != "+k-l secret" {
+		t.Errorf("Expected '+k-l secret', got '%s'", modeStr)
+	}
+}
+
+func TestParseMode_Lists(t *testing.T) {
+	channel, _ := ParseChannelModeString("+bI-e", []string{"foo", "*@host", "bar!baz"}, nil)
+	expected := []ListModeDelta{
+		{List: LIST_BAN, Change: MODE_ADDED, Mask: "foo!*@*"},
+		{List: LIST_INVITE_EXCEPT, Change: MODE_ADDED, Mask: "*!*@host"},
+		{List: LIST_EXCEPT, Change: MODE_REMOVED, Mask: "bar!baz@*"},
+	}
+	if len(channel.Lists) != len(expected) {
+		t.Fatalf("Expected %d list changes, got %d", len(expected), len(channel.Lists))
+	}
+	for i, delta := range expected {
+		if channel.Lists[i] != delta {
+			t.Errorf("Expected %v, got %v", delta, channel.Lists[i])
+		}
+	}
+}
+
+func TestStringifyModes_Lists(t *testing.T) {
+	channel := ChannelModeDelta{
+		Moderated: MODE_ADDED,
+		Lists:     []ListModeDelta{{List: LIST_BAN, Change: MODE_ADDED, Mask: "foo!*@*"}},
+	}
+	modeStr := StringifyChannelModes(channel, []MemberModeDelta{}, nil)
+	if modeStr != "+mb foo!*@*" {
+		t.Errorf("Expected '+mb foo!*@*', got '%s'", modeStr)
+	}
+}
+
+func TestParseMode_InviteOnly(t *testing.T) {
+	channel, _ := ParseChannelModeString("+i", []string{}, nil)
+	if channel.InviteOnly != MODE_ADDED {
+		t.Errorf("Expected +i")
+	}
+	modeStr := StringifyChannelModes(ChannelModeDelta{InviteOnly: MODE_REMOVED}, []MemberModeDelta{}, nil)
+	if modeStr != "-i" {
+		t.Errorf("Expected '-i', got '%s'", modeStr)
+	}
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"strings"
+)
+
+// Realm prefixes are separated from names by this character, as in "dev:test"
+// or "#dev:help".
+const SubnetSeparator = ":"
+
+// The client's nick as shown to a viewer in the given subnet: bare within the
+// client's own subnet, otherwise prefixed with the subnet.
+func (c *Client) NameFor(viewer *Subnet) string {
+	if c.Subnet == viewer {
+		return c.Nick
+	}
+	return c.Subnet.Name + SubnetSeparator + c.Nick
+}
+
+// The channel's name, with its leading '#', as shown to a viewer in the given
+// subnet.
+func (ch *Channel) NameFor(viewer *Subnet) string {
+	if ch.Subnet == viewer {
+		return "#" + ch.Name
+	}
+	return "#" + ch.Subnet.Name + SubnetSeparator + ch.Name
+}
+
+// Split a name as typed by a viewer into the subnet it refers to and the name
+// within it. Names without a prefix are in the viewer's own subnet.
+func (n *Node) resolveName(name string, viewer *Subnet) (*Subnet, string, bool) {
+	idx := strings.Index(name, SubnetSeparator)
+	if idx < 0 {
+		return viewer, name, true
+	}
+	subnet, found := n.Subnet[name[:idx]]
+	return subnet, name[idx+len(SubnetSeparator):], found
+}
+
+// Find the client a viewer in the given subnet means by name, such as "test"
+// or "dev:test".
+func (n *Node) ResolveClient(name string, viewer *Subnet) (*Client, bool) {
+	subnet, nick, found := n.resolveName(name, viewer)
+	if !found {
+		return nil, false
+	}
+	client, found := subnet.Client[strings.ToLower(nick)]
+	return client, found
+}
+
+// Find the channel a viewer in the given subnet means by name, such as "#help"
+// or "#dev:help". The leading '#' is optional.
+func (n *Node) ResolveChannel(name string, viewer *Subnet) (*Channel, bool) {
+	subnet, chname, found := n.resolveName(strings.TrimPrefix(name, "#"), viewer)
+	if !found {
+		return nil, false
+	}
+	channel, found := subnet.Channel[strings.ToLower(chname)]
+	return channel, found
+}
+
+// A ResolveClientFn for parsing mode strings typed by a viewer in the given
+// subnet.
+func (n *Node) ClientResolver(viewer *Subnet) ResolveClientFn {
+	return func(name string) (*Client, bool) {
+		return n.ResolveClient(name, viewer)
+	}
+}
+
+// A SerializeClientFn for showing mode changes to a viewer in the given
+// subnet.
+func ClientSerializer(viewer *Subnet) SerializeClientFn {
+	return func(client *Client) string {
+		return client.NameFor(viewer)
+	}
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"testing"
+)
+
+func testNamesNode() (*Node, *Subnet, *Subnet) {
+	n := &Node{Subnet: make(map[string]*Subnet)}
+	test := NewSubnet("test")
+	dev := NewSubnet("dev")
+	n.Subnet[test.Name] = test
+	n.Subnet[dev.Name] = dev
+	dev.Client["test"] = &Client{Subnet: dev, Nick: "Test", Lnick: "test"}
+	dev.Channel["help"] = NewChannel(n, dev, "Help")
+	return n, test, dev
+}
+
+func TestNames_Render(t *testing.T) {
+	_, test, dev := testNamesNode()
+	client := dev.Client["test"]
+	channel := dev.Channel["help"]
+
+	if name := client.NameFor(dev); name != "Test" {
+		t.Errorf("Expected 'Test', got '%s'", name)
+	}
+	if name := client.NameFor(test); name != "dev:Test" {
+		t.Errorf("Expected 'dev:Test', got '%s'", name)
+	}
+	if name := channel.NameFor(dev); name != "#Help" {
+		t.Errorf("Expected '#Help', got '%s'", name)
+	}
+	if name := channel.NameFor(test); name != "#dev:Help" {
+		t.Errorf("Expected '#dev:Help', got '%s'", name)
+	}
+}
+
+func TestNames_Resolve(t *testing.T) {
+	n, test, dev := testNamesNode()
+	client := dev.Client["test"]
+	channel := dev.Channel["help"]
+
+	for _, tc := range []struct {
+		name   string
+		viewer *Subnet
+		found  bool
+	}{
+		{"test", dev, true},
+		{"TEST", dev, true},
+		{"dev:test", test, true},
+		{"dev:test", dev, true},
+		{"test", test, false},
+		{"nope:test", test, false},
+	} {
+		resolved, found := n.ResolveClient(tc.name, tc.viewer)
+		if found != tc.found || (found && resolved != client) {
+			t.Errorf("ResolveClient(%s, %s): expected found=%v", tc.name, tc.viewer.Name, tc.found)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		viewer *Subnet
+		found  bool
+	}{
+		{"#help", dev, true},
+		{"help", dev, true},
+		{"#dev:Help", test, true},
+		{"#help", test, false},
+	} {
+		resolved, found := n.ResolveChannel(tc.name, tc.viewer)
+		if found != tc.found || (found && resolved != channel) {
+			t.Errorf("ResolveChannel(%s, %s): expected found=%v", tc.name, tc.viewer.Name, tc.found)
+		}
+	}
+}
+
+// Mode strings typed in one subnet and shown in another.
+func TestNames_ModeString(t *testing.T) {
+	n, test, dev := testNamesNode()
+	client := dev.Client["test"]
+
+	_, member := ParseChannelModeString("+o", []string{"dev:test"}, n.ClientResolver(test))
+	if len(member) != 1 || member[0].Client != client {
+		t.Fatalf("Expected dev:test to be resolved")
+	}
+	if modeStr := StringifyChannelModes(ChannelModeDelta{}, member, ClientSerializer(test)); modeStr != "+o dev:Test" {
+		t.Errorf("Expected '+o dev:Test', got '%s'", modeStr)
+	}
+	if modeStr := StringifyChannelModes(ChannelModeDelta{}, member, ClientSerializer(dev)); modeStr != "+o Test" {
+		t.Errorf("Expected '+o Test', got '%s'", modeStr)
+	}
+}
@@ -0,0 +1,133 @@
+package lib
+
+// Protocol versions spoken by this library. A link runs at the highest
+// version both servers speak.
+//
+// Version 1: original protocol.
+// Version 2: hello carries the accepted version range, capabilities and
+// network name.
+const (
+	PROTOCOL_VERSION     uint32 = 2
+	PROTOCOL_MIN_VERSION uint32 = 1
+)
+
+// Capabilities are optional features a server may offer in its hello. A
+// feature is used on a link only if both servers offer it. Capability names
+// are sent on the wire - do not rename them.
+const (
+	// SSPing/SSPong keepalives.
+	CAP_KEEPALIVE = "keepalive"
+
+	// Length-prefixed framing of every message after the hello.
+	CAP_FRAMED = "framed"
+
+	// Framed messages encoded with the binary schema codec instead of gob.
+	CAP_BINARY = "binary"
+
+	// DEFLATE compression of everything after the hello.
+	CAP_DEFLATE = "deflate"
+
+	// SSTopic messages.
+	CAP_TOPIC = "topic"
+
+	// SSNick messages.
+	CAP_NICK = "nick"
+
+	// SSMove messages.
+	CAP_MOVE = "move"
+
+	// SSSubnet, SSSubnetEnd and SSSubnetPolicy messages. Servers without it
+	// only know the default subnet.
+	CAP_SUBNET = "subnet"
+
+	// SSInvite messages.
+	CAP_INVITE = "invite"
+
+	// SSKick messages.
+	CAP_KICK = "kick"
+)
+
+// Every capability supported by this library.
+func SupportedCapabilities() []string {
+	return []string{
+		CAP_KEEPALIVE,
+		CAP_FRAMED,
+		CAP_BINARY,
+		CAP_DEFLATE,
+		CAP_TOPIC,
+		CAP_NICK,
+		CAP_MOVE,
+		CAP_SUBNET,
+		CAP_INVITE,
+		CAP_KICK,
+	}
+}
+
+// The protocol to switch a link to once both hellos have been exchanged, or
+// nil to keep the protocol the hellos were sent with.
+func linkProtocol(capabilities map[string]bool) ServerProtocolFactory {
+	var protocol ServerProtocolFactory
+	if capabilities[CAP_BINARY] {
+		protocol = BinaryServerProtocolFactory
+	} else if capabilities[CAP_FRAMED] {
+		protocol = FramedGobServerProtocolFactory
+	}
+	if capabilities[CAP_DEFLATE] {
+		if protocol == nil {
+			// The gob stream restarts inside the compressed stream.
+			protocol = GobServerProtocolFactory
+		}
+		protocol = CompressedServerProtocolFactory(protocol)
+	}
+	return protocol
+}
+
+// Determine the protocol version to use with the server which sent hello, or
+// an error if there is no version both servers accept.
+func (n *Node) negotiateProtocol(hello *SSHello) (uint32, error) {
+	protocol := PROTOCOL_VERSION
+	if hello.Protocol < protocol {
+		protocol = hello.Protocol
+	}
+	if protocol < n.config.MinProtocol || protocol < hello.MinProtocol {
+		return 0, ProtocolVersionError{
+			Server:    hello.Name,
+			RemoteMin: hello.MinProtocol,
+			RemoteMax: hello.Protocol,
+			LocalMin:  n.config.MinProtocol,
+			LocalMax:  PROTOCOL_VERSION,
+		}
+	}
+	return protocol, nil
+}
+
+// Determine the capabilities offered by both this node and the server which
+// sent hello.
+func (n *Node) negotiateCapabilities(hello *SSHello) map[string]bool {
+	offered := make(map[string]bool)
+	for _, capability := range n.config.Capabilities {
+		offered[capability] = true
+	}
+	agreed := make(map[string]bool)
+	for _, capability := range hello.Capabilities {
+		if offered[capability] {
+			agreed[capability] = true
+		}
+	}
+	return agreed
+}
+
+// Send a message to every local server which agreed to use a capability.
+func (n *Node) SendAllCapable(msg SSMessage, capability string) {
+	n.SendAllCapableSkip(msg, capability, nil)
+}
+
+// Send a message to every local server which agreed to use a capability,
+// except skip.
+func (n *Node) SendAllCapableSkip(msg SSMessage, capability string, skip *Server) {
+	for _, server := range n.Local {
+		if server != skip && server.HasCapability(capability) {
+			server.Send(msg)
+		}
+	}
+}
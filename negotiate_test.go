@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"sync"
+	"testing"
+)
+
+func newNegotiateTestNode(minProtocol uint32, capabilities []string) *Node {
+	config := testConfig("hub.a")
+	config.MinProtocol = minProtocol
+	config.Capabilities = capabilities
+	return &Node{config: config}
+}
+
+func TestNegotiateProtocol_Highest(t *testing.T) {
+	n := newNegotiateTestNode(PROTOCOL_MIN_VERSION, nil)
+	protocol, err := n.negotiateProtocol(&SSHello{Name: "hub.b", Protocol: PROTOCOL_VERSION + 5, MinProtocol: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protocol != PROTOCOL_VERSION {
+		t.Errorf("Expected version %d, got %d", PROTOCOL_VERSION, protocol)
+	}
+}
+
+func TestNegotiateProtocol_OldPeer(t *testing.T) {
+	// Servers predating negotiation send no minimum.
+	n := newNegotiateTestNode(1, nil)
+	protocol, err := n.negotiateProtocol(&SSHello{Name: "hub.b", Protocol: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protocol != 1 {
+		t.Errorf("Expected version 1, got %d", protocol)
+	}
+
+	n = newNegotiateTestNode(2, nil)
+	_, err = n.negotiateProtocol(&SSHello{Name: "hub.b", Protocol: 1})
+	if _, ok := err.(ProtocolVersionError); !ok {
+		t.Errorf("Expected ProtocolVersionError, got %v", err)
+	}
+}
+
+func TestNegotiateProtocol_PeerTooNew(t *testing.T) {
+	n := newNegotiateTestNode(PROTOCOL_MIN_VERSION, nil)
+	_, err := n.negotiateProtocol(&SSHello{Name: "hub.b", Protocol: PROTOCOL_VERSION + 2, MinProtocol: PROTOCOL_VERSION + 1})
+	if _, ok := err.(ProtocolVersionError); !ok {
+		t.Errorf("Expected ProtocolVersionError, got %v", err)
+	}
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	n := newNegotiateTestNode(PROTOCOL_MIN_VERSION, []string{CAP_KEEPALIVE, "local"})
+	agreed := n.negotiateCapabilities(&SSHello{Capabilities: []string{CAP_KEEPALIVE, "remote"}})
+	if len(agreed) != 1 || !agreed[CAP_KEEPALIVE] {
+		t.Errorf("Expected only %s, got %v", CAP_KEEPALIVE, agreed)
+	}
+}
+
+func TestNegotiate_LinkCapabilities(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubA.NewLink("hub.b")
+
+	ch := make(chan *Server)
+	hubA.node.Do(func() {
+		ch <- hubA.node.Network["hub.b"]
+	})
+	server := <-ch
+	if server.Protocol != PROTOCOL_VERSION {
+		t.Errorf("Expected version %d, got %d", PROTOCOL_VERSION, server.Protocol)
+	}
+	for _, capability := range SupportedCapabilities() {
+		if !server.HasCapability(capability) {
+			t.Errorf("Expected capability %s to be agreed", capability)
+		}
+	}
+
+	tn.Shutdown()
+	wg.Wait()
+}
@@ -1,8 +1,10 @@
 package lib
 
 import (
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNetworkChannel_Simple(t *testing.T) {
@@ -132,3 +134,750 @@ func TestNetworkChannel_FarQuit(t *testing.T) {
 	tn.Shutdown()
 	wg.Wait()
 }
+
+func TestNetworkChannel_Burst(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubA.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+	alpha.SetChannelMode(test, "+v", beta)
+
+	// hub.b and hub.c learn of #test only through bursts.
+	hubA.NewLink("hub.b").NewLink("hub.c")
+
+	tn.ExpectAll(test.Member(alpha).IsOwner())
+	tn.ExpectAll(test.Member(beta).IsVoice())
+	tn.ExpectAll(test.HasModes("nt"))
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// Clients which split away stop being members of their channels.
+func TestNetworkChannel_SplitMembers(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+
+	tnB := tn.SplitFromRoot(hubB)
+
+	hubA.Expect(test.Member(alpha).Exists())
+	hubA.Expect(test.Member(beta).Exists().Not())
+	hubB.Expect(test.Member(alpha).Exists().Not())
+	hubB.Expect(test.Member(beta).Exists())
+
+	tn.Shutdown()
+	tnB.Shutdown()
+	wg.Wait()
+}
+
+type modeChangeRecorder struct {
+	ProxyEventHandler
+	changes chan ChannelModeDelta
+}
+
+func (mcr *modeChangeRecorder) OnChannelModeChange(channel *Channel, by *Client, delta ChannelModeDelta, memberDelta []MemberModeDelta) {
+	mcr.changes <- delta
+}
+
+// A channel recreated on each side of a split keeps the older side's modes,
+// timestamp and prefixes when the sides rejoin.
+func TestNetworkChannel_NetjoinOlderWins(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+	tnB := tn.SplitFromRoot(hubB)
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	test := tn.NewChannel("test")
+
+	// hub.b's #test is created first, so it's older.
+	beta.Join(test)
+	beta.SetChannelMode(test, "+ms-t")
+	time.Sleep(time.Millisecond)
+	alpha.Join(test)
+
+	var older time.Time
+	hubB.node.Do(func() {
+		older = hubB.node.DefaultSubnet.Channel["test"].Ts
+	})
+	recorder := &modeChangeRecorder{changes: make(chan ChannelModeDelta, 10)}
+	hubA.node.Do(func() {
+		hubA.node.Handler = recorder
+	})
+
+	hubB.Link(hubA)
+	tnB.Sync()
+
+	tnB.ExpectAll(test.HasModes("mns"))
+	tnB.ExpectAll(test.Member(beta).IsOwner())
+	tnB.ExpectAll(test.Member(alpha).IsOwner().Not())
+	tnB.ExpectAll(test.Member(alpha).Exists())
+	hubA.node.Do(func() {
+		if ts := hubA.node.DefaultSubnet.Channel["test"].Ts; !ts.Equal(older) {
+			t.Errorf("Expected hub.a to adopt the older timestamp %v, got %v", older, ts)
+		}
+	})
+
+	// hub.a saw a single change: its own modes replaced, and alpha demoted.
+	select {
+	case delta := <-recorder.changes:
+		if delta.Moderated != MODE_ADDED || delta.Secret != MODE_ADDED || delta.TopicProtected != MODE_REMOVED {
+			t.Errorf("Expected +ms-t, got %s", delta.String())
+		}
+	default:
+		t.Errorf("Expected a mode change on hub.a")
+	}
+	if len(recorder.changes) != 0 {
+		t.Errorf("Expected a single mode change on hub.a")
+	}
+
+	tn.Shutdown()
+	tnB.Shutdown()
+	wg.Wait()
+}
+
+// When the same channel rejoins after a split, modes set on either side are
+// kept.
+func TestNetworkChannel_NetjoinMerge(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+	alpha.SetChannelMode(test, "+o", beta)
+
+	tnB := tn.SplitFromRoot(hubB)
+	alpha.SetChannelMode(test, "+m")
+	beta.SetChannelMode(test, "+s")
+
+	hubB.Link(hubA)
+	tnB.Sync()
+
+	tnB.ExpectAll(test.HasModes("mnst"))
+	tnB.ExpectAll(test.Member(alpha).IsOwner())
+	tnB.ExpectAll(test.Member(beta).IsOp())
+
+	tn.Shutdown()
+	tnB.Shutdown()
+	wg.Wait()
+}
+
+func TestNetworkChannel_Topic(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+
+	if err := alpha.SetTopic(test, "Welcome"); err != nil {
+		t.Fatalf("Failed to set topic: %v", err)
+	}
+	tn.ExpectAll(test.HasTopic("Welcome"))
+
+	// #test is +t, and beta has no rank.
+	if _, ok := beta.SetTopic(test, "Mine now").(ChannelPrivilegesNeededError); !ok {
+		t.Errorf("Expected ChannelPrivilegesNeededError")
+	}
+	tn.ExpectAll(test.HasTopic("Welcome"))
+
+	alpha.SetChannelMode(test, "-t")
+	if err := beta.SetTopic(test, "Mine now"); err != nil {
+		t.Fatalf("Failed to set topic: %v", err)
+	}
+	tn.ExpectAll(test.HasTopic("Mine now"))
+
+	// Topics are part of the burst.
+	hubB.NewLink("hub.c")
+	tn.ExpectAll(test.HasTopic("Mine now"))
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// When the same channel rejoins after a split, the most recently set topic
+// wins.
+func TestNetworkChannel_NetjoinTopic(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+	alpha.SetChannelMode(test, "+o", beta)
+	alpha.SetTopic(test, "Before")
+
+	tnB := tn.SplitFromRoot(hubB)
+	alpha.SetTopic(test, "Older")
+	time.Sleep(time.Millisecond)
+	beta.SetTopic(test, "Newer")
+
+	hubA.Link(hubB)
+	tn.Sync()
+
+	tn.ExpectAll(test.HasTopic("Newer"))
+
+	tn.Shutdown()
+	tnB.Shutdown()
+	wg.Wait()
+}
+
+// A topic change older than the current topic, as when two changes cross on
+// the network, is dropped rather than applied and passed on.
+func TestNetworkChannel_StaleTopic(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+	hubB.NewLink("hub.c")
+
+	alpha := hubA.NewClient("alpha")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	alpha.SetTopic(test, "Current")
+	tn.ExpectAll(test.HasTopic("Current"))
+
+	node := hubB.node
+	node.Do(func() {
+		channel, _ := node.lookupChannelById(SSChannelId{Subnet: "test", Name: "test"})
+		node.handleTopic(&SSTopic{
+			From:    alpha.client.Id(),
+			Channel: channel.Id(),
+			Topic:   "Stale",
+			Ts:      channel.TopicTs.Add(-time.Second),
+			By:      "alpha",
+		}, node.Network["hub.a"])
+	})
+	tn.Sync()
+	tn.ExpectAll(test.HasTopic("Current"))
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// Clients may join channels in other subnets, as that subnet's policy allows.
+func TestNetworkChannel_ForeignJoin(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+	hubA.CreateSubnet("dev", "Developers")
+
+	alpha := hubA.NewClient("alpha")
+	alpha.Move("dev", "alpha")
+	beta := hubB.NewClient("beta")
+	help := tn.NewChannelIn("dev", "help")
+	alpha.Join(help)
+
+	// Open by default.
+	beta.Join(help)
+	tn.ExpectAll(help.Member(alpha).IsOwner())
+	tn.ExpectAll(help.Member(beta).Exists())
+	beta.Part(help, "")
+
+	hubA.SetForeignJoinPolicy("dev", FOREIGN_JOIN_CLOSED)
+	if _, ok := beta.TryJoin(help).(ForeignJoinDeniedError); !ok {
+		t.Errorf("Expected ForeignJoinDeniedError")
+	}
+
+	hubA.SetForeignJoinPolicy("dev", FOREIGN_JOIN_INVITE)
+	if _, ok := beta.TryJoin(help).(InviteOnlyError); !ok {
+		t.Errorf("Expected InviteOnlyError")
+	}
+	hubB.node.Do(func() {
+		channel, _ := help.lookupOn(hubB.node)
+		channel.Invites[beta.client] = struct{}{}
+	})
+	if err := beta.TryJoin(help); err != nil {
+		t.Fatalf("Failed to join with an invite: %v", err)
+	}
+	tn.ExpectAll(help.Member(beta).Exists())
+
+	// Foreign memberships and the policy are part of the burst.
+	hubC := hubB.NewLink("hub.c")
+	tn.ExpectAll(help.Member(beta).Exists())
+	gamma := hubC.NewClient("gamma")
+	if _, ok := gamma.TryJoin(help).(InviteOnlyError); !ok {
+		t.Errorf("Expected InviteOnlyError")
+	}
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+func TestNetworkChannel_KeyLimit(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	gamma := hubB.NewClient("gamma")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	alpha.SetChannelMode(test, "+kl", "secret", 2)
+	tn.ExpectAll(test.HasModes("ntk(secret)l(2)"))
+
+	if _, ok := beta.TryJoinWithKey(test, "wrong").(BadChannelKeyError); !ok {
+		t.Errorf("Expected BadChannelKeyError")
+	}
+	if err := beta.TryJoinWithKey(test, "secret"); err != nil {
+		t.Fatalf("Failed to join with the key: %v", err)
+	}
+	if _, ok := gamma.TryJoinWithKey(test, "secret").(ChannelFullError); !ok {
+		t.Errorf("Expected ChannelFullError")
+	}
+
+	// Only ranked members may change them.
+	beta.SetChannelMode(test, "-kl")
+	tn.ExpectAll(test.HasModes("ntk(secret)l(2)"))
+
+	alpha.SetChannelMode(test, "-kl")
+	tn.ExpectAll(test.HasModes("nt"))
+	if err := gamma.TryJoin(test); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+func TestNetworkChannel_Bans(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	gamma := hubB.NewClient("gamma")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	gamma.Join(test)
+
+	alpha.SetChannelMode(test, "+b", "*!*@host.beta")
+	tn.ExpectAll(test.HasListEntry(LIST_BAN, "*!*@host.beta", "alpha"))
+	if _, ok := beta.TryJoin(test).(BannedFromChannelError); !ok {
+		t.Errorf("Expected BannedFromChannelError")
+	}
+	alpha.SetChannelMode(test, "+e", "beta!*@*")
+	tn.ExpectAll(test.HasListEntry(LIST_EXCEPT, "beta!*@*", "alpha"))
+	if err := beta.TryJoin(test); err != nil {
+		t.Fatalf("Failed to join with an exception: %v", err)
+	}
+
+	// Banned members can neither speak nor change nick, unless voiced.
+	alpha.SetChannelMode(test, "+b", "gamma!*@*")
+	if _, ok := gamma.Say(test, "hello").(BannedFromChannelError); !ok {
+		t.Errorf("Expected BannedFromChannelError")
+	}
+	if _, ok := gamma.ChangeNick("delta").(BannedNickChangeError); !ok {
+		t.Errorf("Expected BannedNickChangeError")
+	}
+	alpha.SetChannelMode(test, "+v", gamma)
+	if err := gamma.Say(test, "hello"); err != nil {
+		t.Errorf("Failed to speak with voice: %v", err)
+	}
+
+	// Lists are part of the burst.
+	hubB.NewLink("hub.c")
+	tn.ExpectAll(test.HasListEntry(LIST_BAN, "*!*@host.beta", "alpha"))
+	tn.ExpectAll(test.HasListEntry(LIST_EXCEPT, "beta!*@*", "alpha"))
+
+	alpha.SetChannelMode(test, "-b", "*!*@host.beta")
+	tn.ExpectAll(test.HasListEntry(LIST_BAN, "*!*@host.beta", "alpha").Not())
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// When the same channel rejoins after a split, entries set on either side are
+// kept.
+func TestNetworkChannel_NetjoinLists(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+	alpha.SetChannelMode(test, "+o", beta)
+	tnB := tn.SplitFromRoot(hubB)
+
+	alpha.SetChannelMode(test, "+b", "*!*@a.example")
+	beta.SetChannelMode(test, "+I", "*!*@b.example")
+
+	hubB.Link(hubA)
+	tnB.Sync()
+	tnB.ExpectAll(test.HasListEntry(LIST_BAN, "*!*@a.example", "alpha"))
+	tnB.ExpectAll(test.HasListEntry(LIST_INVITE_EXCEPT, "*!*@b.example", "beta"))
+
+	tn.Shutdown()
+	tnB.Shutdown()
+	wg.Wait()
+}
+
+// Invites don't outlive the invited client.
+func TestNetworkChannel_InviteQuit(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubA.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	alpha.SetChannelMode(test, "+i")
+	if err := alpha.Invite(beta, test); err != nil {
+		t.Fatalf("Failed to invite: %v", err)
+	}
+	beta.Quit("bye")
+
+	hubA.node.Do(func() {
+		channel, _ := test.lookupOn(hubA.node)
+		if len(channel.Invites) != 0 {
+			t.Errorf("Expected no invites after the invited client quit, got %d", len(channel.Invites))
+		}
+	})
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+type inviteRecorder struct {
+	ProxyEventHandler
+	invites chan string
+}
+
+func (ir *inviteRecorder) OnInvite(from *Client, to *Client, channel *Channel) {
+	ir.invites <- from.Nick + " -> " + to.Nick + " " + channel.Name
+}
+
+func TestNetworkChannel_InviteOnly(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	// hub.c doesn't speak CAP_INVITE, so invites to its clients are lost.
+	hubC := tn.NewServer("hub.c")
+	hubC.node.config.Capabilities = []string{CAP_KEEPALIVE}
+	hubB.Link(hubC)
+
+	recorder := &inviteRecorder{invites: make(chan string, 10)}
+	hubB.node.Do(func() {
+		hubB.node.Handler = recorder
+	})
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	gamma := hubA.NewClient("gamma")
+	delta := hubC.NewClient("delta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	alpha.SetChannelMode(test, "+i")
+	tn.ExpectAll(test.HasModes("int"))
+
+	if _, ok := beta.TryJoin(test).(InviteOnlyError); !ok {
+		t.Errorf("Expected InviteOnlyError")
+	}
+	if _, ok := beta.Invite(gamma, test).(NotOnChannelError); !ok {
+		t.Errorf("Expected NotOnChannelError")
+	}
+	if err := alpha.Invite(beta, test); err != nil {
+		t.Fatalf("Failed to invite: %v", err)
+	}
+	if invite := <-recorder.invites; invite != "alpha -> beta test" {
+		t.Errorf("Unexpected invite: %s", invite)
+	}
+	if err := beta.TryJoin(test); err != nil {
+		t.Fatalf("Failed to join with an invite: %v", err)
+	}
+	if _, ok := alpha.Invite(beta, test).(AlreadyAMemberError); !ok {
+		t.Errorf("Expected AlreadyAMemberError")
+	}
+	if _, ok := beta.Invite(gamma, test).(ChannelPrivilegesNeededError); !ok {
+		t.Errorf("Expected ChannelPrivilegesNeededError")
+	}
+
+	// The invite is used up by joining.
+	beta.Part(test, "")
+	if _, ok := beta.TryJoin(test).(InviteOnlyError); !ok {
+		t.Errorf("Expected InviteOnlyError after the invite was used")
+	}
+
+	// Invite exceptions need no invite.
+	alpha.SetChannelMode(test, "+I", "gamma")
+	if err := gamma.TryJoin(test); err != nil {
+		t.Fatalf("Failed to join with an invite exception: %v", err)
+	}
+
+	// hub.a can only tell that hub.b takes invites; hub.b drops this one.
+	if err := alpha.Invite(delta, test); err != nil {
+		t.Fatalf("Failed to invite: %v", err)
+	}
+	// hub.b can tell that hub.c doesn't.
+	epsilon := hubB.NewClient("epsilon")
+	alpha.SetChannelMode(test, "+I", "epsilon")
+	epsilon.Join(test)
+	alpha.SetChannelMode(test, "+h", epsilon)
+	if _, ok := epsilon.Invite(delta, test).(InviteUnsupportedError); !ok {
+		t.Errorf("Expected InviteUnsupportedError without CAP_INVITE")
+	}
+	if _, ok := delta.TryJoin(test).(InviteOnlyError); !ok {
+		t.Errorf("Expected InviteOnlyError without CAP_INVITE")
+	}
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+type channelMessageRecorder struct {
+	ProxyEventHandler
+	messages chan string
+}
+
+func (cmr *channelMessageRecorder) OnChannelMessage(from *Client, to *Channel, message string) {
+	cmr.messages <- from.Nick + ": " + message
+}
+
+func TestNetworkChannel_CanSend(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := tn.NewServer("hub.b")
+	hubB.node.config.CheckRemoteChannelMessages = true
+	hubA.Link(hubB)
+	hubC := hubA.NewLink("hub.c")
+
+	recorderB := &channelMessageRecorder{messages: make(chan string, 10)}
+	recorderC := &channelMessageRecorder{messages: make(chan string, 10)}
+	hubB.node.Do(func() {
+		hubB.node.Handler = recorderB
+	})
+	hubC.node.Do(func() {
+		hubC.node.Handler = recorderC
+	})
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubA.NewClient("beta")
+	gamma := hubA.NewClient("gamma")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+
+	if _, ok := gamma.Say(test, "hello").(NoExternalMessagesError); !ok {
+		t.Errorf("Expected NoExternalMessagesError")
+	}
+	alpha.SetChannelMode(test, "-n+m")
+	if _, ok := gamma.Say(test, "hello").(ModeratedChannelError); !ok {
+		t.Errorf("Expected ModeratedChannelError")
+	}
+	if _, ok := beta.Say(test, "hello").(ModeratedChannelError); !ok {
+		t.Errorf("Expected ModeratedChannelError")
+	}
+	alpha.SetChannelMode(test, "+v", beta)
+	if err := beta.Say(test, "voiced"); err != nil {
+		t.Fatalf("Failed to speak with voice: %v", err)
+	}
+	for _, recorder := range []*channelMessageRecorder{recorderB, recorderC} {
+		if msg := <-recorder.messages; msg != "beta: voiced" {
+			t.Errorf("Unexpected message: %s", msg)
+		}
+	}
+
+	// A message which skips the check on its origin server is still dropped
+	// by hub.b.
+	hubA.node.Do(func() {
+		hubA.node.SendAll(&SSChannelMessage{
+			From:    gamma.client.Id(),
+			To:      SSChannelId{Subnet: hubA.node.DefaultSubnet.Name, Name: "test"},
+			Message: "sneaky",
+		})
+	})
+	tn.Sync()
+	if msg := <-recorderC.messages; msg != "gamma: sneaky" {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+	select {
+	case msg := <-recorderB.messages:
+		t.Errorf("Unexpected message on hub.b: %s", msg)
+	default:
+	}
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+type kickRecorder struct {
+	ProxyEventHandler
+	kicks chan string
+}
+
+func (kr *kickRecorder) OnChannelKick(channel *Channel, by *Client, target *Client, reason string) {
+	kr.kicks <- by.Nick + " kicked " + target.Nick + " from " + channel.Name + ": " + reason
+}
+
+func TestNetworkChannel_Kick(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	// hub.c doesn't speak CAP_KICK, and sees kicks as parts.
+	hubC := tn.NewServer("hub.c")
+	hubC.node.config.Capabilities = []string{CAP_KEEPALIVE}
+	hubB.Link(hubC)
+
+	recorder := &kickRecorder{kicks: make(chan string, 10)}
+	hubB.node.Do(func() {
+		hubB.node.Handler = recorder
+	})
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	gamma := hubC.NewClient("gamma")
+	delta := hubB.NewClient("delta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+	gamma.Join(test)
+	delta.Join(test)
+	alpha.SetChannelMode(test, "+h", delta)
+
+	if _, ok := beta.Kick(test, gamma, "").(ChannelPrivilegesNeededError); !ok {
+		t.Errorf("Expected ChannelPrivilegesNeededError")
+	}
+	if _, ok := delta.Kick(test, alpha, "").(ChannelPrivilegesNeededError); !ok {
+		t.Errorf("Expected ChannelPrivilegesNeededError")
+	}
+	if err := delta.Kick(test, beta, "behave"); err != nil {
+		t.Fatalf("Failed to kick: %v", err)
+	}
+	if kick := <-recorder.kicks; kick != "delta kicked beta from test: behave" {
+		t.Errorf("Unexpected kick: %s", kick)
+	}
+	tn.ExpectAll(test.Member(beta).Exists().Not())
+	if _, ok := alpha.Kick(test, beta, "").(UserNotOnChannelError); !ok {
+		t.Errorf("Expected UserNotOnChannelError")
+	}
+
+	if err := alpha.Kick(test, gamma, "bye"); err != nil {
+		t.Fatalf("Failed to kick: %v", err)
+	}
+	if kick := <-recorder.kicks; kick != "alpha kicked gamma from test: bye" {
+		t.Errorf("Unexpected kick: %s", kick)
+	}
+	tn.ExpectAll(test.Member(gamma).Exists().Not())
+	tn.ExpectAll(test.Member(delta).Exists())
+
+	// Admins rank between owners and ops.
+	epsilon := hubA.NewClient("epsilon")
+	epsilon.Join(test)
+	alpha.SetChannelMode(test, "+a", delta)
+	alpha.SetChannelMode(test, "+o", epsilon)
+	if _, ok := delta.Kick(test, alpha, "").(ChannelPrivilegesNeededError); !ok {
+		t.Errorf("Expected ChannelPrivilegesNeededError")
+	}
+	delta.SetChannelMode(test, "-o", epsilon)
+	tn.ExpectAll(test.Member(epsilon).IsOp().Not())
+	delta.SetChannelMode(test, "+o", epsilon)
+	tn.ExpectAll(test.Member(epsilon).IsOp())
+	if err := delta.Kick(test, epsilon, "out"); err != nil {
+		t.Fatalf("Failed to kick as admin: %v", err)
+	}
+	if kick := <-recorder.kicks; kick != "delta kicked epsilon from test: out" {
+		t.Errorf("Unexpected kick: %s", kick)
+	}
+	tn.ExpectAll(test.Member(epsilon).Exists().Not())
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// Kicks are ignored where the target rejoined after the kick was made, or
+// where the channel is older than the kicker's copy of it. In the latter case
+// the target is put back on the kicker's side.
+func TestNetworkChannel_KickRace(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+	hubB := hubA.NewLink("hub.b")
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+
+	kick := func(shift func(channelTs, mshipTs time.Time) (time.Time, time.Time)) {
+		hubA.node.Do(func() {
+			channel, _ := test.lookupOn(hubA.node)
+			target, _ := beta.lookupOn(hubA.node)
+			channelTs, ts := shift(channel.Ts, channel.Member[target].Ts)
+			hubA.node.removeMember(channel, target)
+			hubA.node.sendKick(&SSKick{
+				From:      alpha.client.Id(),
+				Channel:   channel.Id(),
+				Target:    target.Id(),
+				ChannelTs: channelTs,
+				Ts:        ts,
+			}, nil)
+		})
+		tn.Sync()
+	}
+
+	kick(func(channelTs, mshipTs time.Time) (time.Time, time.Time) {
+		return channelTs.Add(time.Hour), mshipTs.Add(time.Second)
+	})
+	tn.ExpectAll(test.Member(beta).Exists())
+
+	kick(func(channelTs, mshipTs time.Time) (time.Time, time.Time) {
+		return channelTs, mshipTs.Add(-time.Second)
+	})
+	hubB.Expect(test.Member(beta).Exists())
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// A channel too big for one burst message arrives whole.
+func TestNetworkChannel_BurstSplit(t *testing.T) {
+	var wg sync.WaitGroup
+	tn, hubA := newTestNetwork(t, "hub.a", &wg)
+
+	alpha := hubA.NewClient("alpha")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	for i := 0; i < MaxListEntries; i++ {
+		alpha.SetChannelMode(test, "+b", fmt.Sprintf("*!*@%d.example", i))
+		alpha.SetChannelMode(test, "+e", fmt.Sprintf("*!*@%d.example", i))
+	}
+
+	hubA.NewLink("hub.b")
+
+	tn.ExpectAll(test.Member(alpha).IsOwner())
+	tn.ExpectAll(test.HasListEntry(LIST_BAN, "*!*@0.example", "alpha"))
+	tn.ExpectAll(test.HasListEntry(LIST_BAN, fmt.Sprintf("*!*@%d.example", MaxListEntries-1), "alpha"))
+	tn.ExpectAll(test.HasListEntry(LIST_EXCEPT, fmt.Sprintf("*!*@%d.example", MaxListEntries-1), "alpha"))
+
+	tn.Shutdown()
+	wg.Wait()
+}
@@ -1,8 +1,11 @@
 package lib
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"log"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -20,8 +23,50 @@ type Config struct {
 
 	// Name of the default subnet (must match for networks to link)
 	DefaultSubnetName string
+
+	// Pool of network CA certificates. If set, every server link must be made
+	// over TLS with a certificate issued by the network CA to the name the
+	// server announces in its hello.
+	NetworkCA *x509.CertPool
+
+	// How often each link is pinged, and how long a ping may go unanswered
+	// before the link is split. Zero values select the defaults.
+	PingInterval, PingTimeout time.Duration
+
+	// How long a new link may take to say hello before it is closed. Zero
+	// selects the default.
+	HandshakeTimeout time.Duration
+
+	// Lowest protocol version to accept from linking servers. Zero selects
+	// PROTOCOL_MIN_VERSION.
+	MinProtocol uint32
+
+	// Optional features to offer linking servers. Nil selects every
+	// capability this library supports.
+	Capabilities []string
+
+	// Opens connections to peers added with AddPeer(). Nil dials over
+	// plain TCP; a *tls.Conn returned here is verified like any other TLS
+	// link.
+	Dial func(peer Peer) (net.Conn, error)
+
+	// Range of delays before redialing a peer, doubling with each
+	// consecutive failure. Zero values select the defaults.
+	ConnectBackoffMin, ConnectBackoffMax time.Duration
+
+	// Check channel messages from other servers against CanSendToChannel too,
+	// dropping those which fail. Messages sent just as a client lost voice
+	// may then reach only part of the network.
+	CheckRemoteChannelMessages bool
 }
 
+const (
+	DefaultPingInterval = 30 * time.Second
+	DefaultPingTimeout  = 90 * time.Second
+
+	DefaultHandshakeTimeout = 30 * time.Second
+)
+
 // Represents a Gossamer distributed node's current state.
 type Node struct {
 	// Configuration
@@ -46,6 +91,9 @@ type Node struct {
 	syncId      uint32
 	syncsActive map[uint32]*syncRecord
 
+	// Certificate revocation lists loaded from the network CA.
+	revocations []*x509.RevocationList
+
 	DefaultSubnet *Subnet
 	Me            *Server
 	Handler       EventHandler
@@ -54,10 +102,56 @@ type Node struct {
 	linkReadWg *sync.WaitGroup
 
 	todo chan NodeDoFn
+
+	// Fires at the next keepalive or handshake deadline of any link.
+	linkTimer *time.Timer
+
+	// Peers to link to automatically, the peer currently being dialed (if
+	// any) and its link once the connection is open.
+	peers        map[string]*peerState
+	dialing      *peerState
+	dialingLink  *Link
+	dialed       chan dialResult
+	connectTimer *time.Timer
+
+	// Closed once the node has exited.
+	done chan struct{}
+
+	// Listeners passed to Serve(), and accepted connections whose links
+	// haven't begun. Guarded by serveMutex, as they're used outside the node
+	// goroutine.
+	serveMutex sync.Mutex
+	stopped    bool
+	listeners  map[net.Listener]struct{}
+	accepted   map[net.Conn]struct{}
 }
 
 func NewNode(config Config, handler EventHandler, wg *sync.WaitGroup) *Node {
 	validateConfig(config)
+	if config.PingInterval == 0 {
+		config.PingInterval = DefaultPingInterval
+	}
+	if config.PingTimeout == 0 {
+		config.PingTimeout = DefaultPingTimeout
+	}
+	if config.HandshakeTimeout == 0 {
+		config.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if config.MinProtocol == 0 {
+		config.MinProtocol = PROTOCOL_MIN_VERSION
+	}
+	if config.Capabilities == nil {
+		config.Capabilities = SupportedCapabilities()
+	}
+	if config.Dial == nil {
+		config.Dial = defaultDial(config)
+	}
+	if config.ConnectBackoffMin == 0 {
+		config.ConnectBackoffMin = DefaultConnectBackoffMin
+	}
+	if config.ConnectBackoffMax == 0 {
+		config.ConnectBackoffMax = DefaultConnectBackoffMax
+	}
 
 	node := &Node{
 		config: config,
@@ -79,7 +173,18 @@ func NewNode(config Config, handler EventHandler, wg *sync.WaitGroup) *Node {
 		wg:         wg,
 		linkReadWg: &sync.WaitGroup{},
 		todo:       make(chan NodeDoFn),
+		linkTimer:  time.NewTimer(0),
+
+		peers:        make(map[string]*peerState),
+		dialed:       make(chan dialResult),
+		connectTimer: time.NewTimer(0),
+		done:         make(chan struct{}),
+
+		listeners: make(map[net.Listener]struct{}),
+		accepted:  make(map[net.Conn]struct{}),
 	}
+	node.linkTimer.Stop()
+	node.connectTimer.Stop()
 	node.Network[node.Me.Name] = node.Me
 	node.Subnet[node.DefaultSubnet.Name] = node.DefaultSubnet
 	wg.Add(1)
@@ -94,21 +199,46 @@ func (n *Node) linkReadClose() {
 	close(n.linkRecv)
 }
 
-func (n *Node) Do(fn NodeDoFn) {
-	n.todo <- fn
+// Run fn on the node goroutine. Returns false without running fn if the node
+// has exited.
+func (n *Node) Do(fn NodeDoFn) bool {
+	select {
+	case n.todo <- fn:
+		return true
+	case <-n.done:
+		return false
+	}
 }
 
 func (n *Node) run() {
 	defer n.wg.Done()
+	defer n.linkTimer.Stop()
+	defer n.connectTimer.Stop()
+	defer close(n.done)
 	for {
+		// Everything written while handling the last event goes out before
+		// waiting for the next one.
+		n.flushLinks()
 		select {
 		case <-n.exit:
+			for link, _ := range n.Local {
+				link.Shutdown()
+			}
+			for link, _ := range n.NewLinks {
+				link.Close()
+			}
 			n.linkReadWg.Done()
 			for _ = range n.linkRecv {
 			}
 			return
 		case todo := <-n.todo:
 			todo()
+		case now := <-n.linkTimer.C:
+			n.checkLinks(now)
+		case now := <-n.connectTimer.C:
+			n.autoconnect(now)
+		case result := <-n.dialed:
+			n.handleDialResult(result)
 		case msg := <-n.linkRecv:
 			if msg.link == nil {
 				log.Fatalf("[%s] Nil link?", n.Me.Name)
@@ -127,6 +257,14 @@ func (n *Node) run() {
 					log.Fatalf("[%s] Expected to find server for link {%s/%v}: [%v].", n.Me.Name, msg.link.name, msg.link.Silence, msg.err)
 				}
 
+				if _, ok := msg.err.(CorruptFrameError); ok {
+					log.Printf("[%s] Skipping corrupt frame over link {%s} from server %s: %v", n.Me.Name, msg.link.name, server.Name, msg.err)
+					continue
+				}
+				if _, ok := msg.err.(UnknownMessageTypeError); ok {
+					n.protocolViolation(server, msg.err.Error())
+					continue
+				}
 				if msg.err != nil {
 					log.Printf("[%s] Error [%v] over link {%s} from server %s", n.Me.Name, msg.err, msg.link.name, server.Name)
 					// Error on the link. Need to split.
@@ -140,6 +278,25 @@ func (n *Node) run() {
 	}
 }
 
+// Flush every link, and split those which failed. Splitting writes to the
+// remaining links, which may fail in turn.
+func (n *Node) flushLinks() {
+	for {
+		var failed *Link
+		for link, server := range n.Local {
+			err := link.Flush()
+			if err != nil {
+				log.Printf("[%s] Error [%v] writing to link {%s} to server %s", n.Me.Name, err, link.name, server.Name)
+				failed = link
+			}
+		}
+		if failed == nil {
+			return
+		}
+		n.split(failed, failed.failed)
+	}
+}
+
 func (n *Node) bumpVersion() {
 	n.version++
 	if n.versionMon != nil {
@@ -157,13 +314,16 @@ func (n *Node) split(link *Link, err error) {
 	// First, determine the server to which this link connects.
 	server, found := n.Local[link]
 	if !found {
-		log.Fatalf("Got split() for link {%s} that doesn't exist for error: %v", link.name, err)
+		// Already split, e.g. for an earlier violation in the same message.
+		log.Printf("[%s] Got split() for link {%s} that doesn't exist for error: %v", n.Me.Name, link.name, err)
+		return
 	}
 
 	log.Printf("SPLIT: %s", link.name)
 	link.Silence = true
 	delete(n.Local, link)
 	link.Close()
+	n.peerSplit(link, server.Name)
 
 	// Next, break the link, and resolve the consequences.
 	n.processSplit(server, err.Error())
@@ -175,6 +335,15 @@ func (n *Node) split(link *Link, err error) {
 	log.Printf("[%s] split from %s: %v", n.Me.Name, server.Name, err)
 }
 
+// Handle a protocol violation by a local server. Only the link to that server
+// is split - the rest of the network is unaffected.
+func (n *Node) protocolViolation(server *Server, reason string) {
+	err := ProtocolViolationError{server.Name, reason}
+	log.Printf("[%s] %v", n.Me.Name, err)
+	n.Handler.OnProtocolViolation(server, err)
+	n.split(server.Link, err)
+}
+
 func (n *Node) processSplit(server *Server, err string) {
 	delete(n.Network, server.Name)
 	delete(server.Hub.Links, server.Name)
@@ -187,17 +356,22 @@ func (n *Node) processSplit(server *Server, err string) {
 			}
 		}
 		for _, client := range removeList {
-			delete(client.Subnet.Client, client.Lnick)
+			n.processQuit(client, err, SS_KILL_REASON_SPLIT)
 		}
 	}
 
 	for _, linked := range server.Links {
 		n.processSplit(linked, err)
 	}
+
+	// A peer may have been reachable only through the server which split.
+	n.scheduleConnect()
 }
 
-func (n *Node) processQuit(client *Client, reason string) {
+// Remove a client which left the network, for whatever reason code says.
+func (n *Node) processQuit(client *Client, reason string, code SSKillReason) {
 	log.Printf("[%s] processing quit of %s:%s", n.Me.Name, client.Subnet.Name, client.Nick)
+	n.Handler.OnClientQuit(client, reason, code)
 
 	for channel, _ := range client.Member {
 		delete(channel.Member, client)
@@ -208,19 +382,127 @@ func (n *Node) processQuit(client *Client, reason string) {
 			delete(channel.Subnet.Channel, channel.Lname)
 		}
 	}
+	// Only local clients are ever invited.
+	if client.IsLocal() {
+		for _, subnet := range n.Subnet {
+			for _, channel := range subnet.Channel {
+				delete(channel.Invites, client)
+			}
+		}
+	}
+
+	delete(client.Subnet.Client, client.Lnick)
+}
+
+// Remove a client killed by an SSKill, which is then authoritative.
+// OnClientKilled is fired for anything but a voluntary quit.
+func (n *Node) processKill(client *Client, msg *SSKill) {
+	if msg.ReasonCode != SS_KILL_REASON_QUIT {
+		// The killer may be gone by now.
+		by, _ := n.lookupClientById(msg.By)
+		n.Handler.OnClientKilled(client, by, msg.Reason, msg.ReasonCode)
+	}
+	n.processQuit(client, msg.Reason, msg.ReasonCode)
+}
+
+// Remove a client from a channel, deleting the channel once it's empty.
+func (n *Node) removeMember(channel *Channel, client *Client) {
+	delete(channel.Member, client)
+	delete(channel.LocalMember, client)
+	delete(client.Member, channel)
+	if len(channel.Member) == 0 {
+		delete(channel.Subnet.Channel, channel.Lname)
+	}
+}
+
+// Send a kick to every local server except skip. Servers without CAP_KICK are
+// told the target parted instead.
+func (n *Node) sendKick(msg *SSKick, skip *Server) {
+	for _, server := range n.Local {
+		if server == skip {
+			continue
+		}
+		if server.HasCapability(CAP_KICK) {
+			server.Send(msg)
+			continue
+		}
+		server.Send(&SSMembershipEnd{
+			Channel: msg.Channel,
+			Client:  msg.Target,
+			Reason:  msg.Reason,
+		})
+	}
+}
 
+// Re-index a client under a new nick, possibly in another subnet.
+func (n *Node) renameClient(client *Client, subnet *Subnet, nick string, ts time.Time) {
 	delete(client.Subnet.Client, client.Lnick)
+	client.Subnet = subnet
+	client.Nick = nick
+	client.Lnick = strings.ToLower(nick)
+	client.Ts = ts
+	subnet.Client[client.Lnick] = client
+}
+
+// Send a nick change or move of a client, previously known as oldId, to every
+// local server except skip. Servers without the given capability are told the
+// client quit and came back under its new identity instead, followed by its
+// channels so that its memberships are restored.
+func (n *Node) sendRename(msg SSMessage, capability string, oldId SSClientId, client *Client, skip *Server) {
+	for _, server := range n.Local {
+		if server == skip {
+			continue
+		}
+		if server.HasCapability(capability) {
+			server.Send(msg)
+			continue
+		}
+		server.Send(&SSKill{
+			Id:         oldId,
+			Server:     client.Server.Name,
+			Authority:  true,
+			Reason:     "Nick change",
+			ReasonCode: SS_KILL_REASON_QUIT,
+		})
+		server.Send(client.Serialize())
+		for channel, _ := range client.Member {
+			for _, msg := range channel.SerializeBurst() {
+				server.Send(msg)
+			}
+		}
+	}
 }
 
 func (n *Node) BurstTo(newServer *Server) {
 	for _, server := range n.Local {
 		n.burstServerHelper(newServer, server)
 	}
+	// Subnets come before anything in them, for servers which can hear of
+	// them. Every server has its own default subnet already, so only its
+	// policy is sent, if it was ever set.
+	if newServer.HasCapability(CAP_SUBNET) {
+		for _, subnet := range n.Subnet {
+			if subnet != n.DefaultSubnet {
+				newServer.Send(subnet.Serialize())
+			} else if !subnet.PolicyTs.IsZero() {
+				newServer.Send(&SSSubnetPolicy{subnet.Name, subnet.ForeignJoin, subnet.PolicyTs})
+			}
+		}
+	}
 	for _, subnet := range n.Subnet {
 		for _, client := range subnet.Client {
 			newServer.Send(client.Serialize())
 		}
 	}
+	// Channels follow the clients, so that every member is known by the time
+	// its channel arrives.
+	for _, subnet := range n.Subnet {
+		for _, channel := range subnet.Channel {
+			for _, msg := range channel.SerializeBurst() {
+				newServer.Send(msg)
+			}
+		}
+	}
 	newServer.Send(SSBurstComplete{n.Me.Name})
 }
 
@@ -231,16 +513,16 @@ func (n *Node) burstServerHelper(newServer *Server, hub *Server) {
 	}
 }
 
+// Stop accepting links, close every link and exit the node goroutine. Safe
+// to call from any goroutine, and more than once.
 func (n *Node) Shutdown() {
-	for link, _ := range n.Local {
-		link.Shutdown()
-	}
-	for link, _ := range n.NewLinks {
-		link.Close()
+	if n.stopServing() {
+		n.exit <- struct{}{}
 	}
-	n.exit <- struct{}{}
 }
 
+// Introduce a local client to the network. Like every Node API, it must be
+// called on the node goroutine, e.g. through Do.
 func (n *Node) AttachClient(client *Client) error {
 	if client.Server != nil {
 		log.Fatalf("Client is already attached [%s]", client.DebugString())
@@ -358,7 +640,7 @@ func (n *Node) lookupClientById(id SSClientId) (client *Client, found bool) {
 	// Client ids contain the name of the server hosting the client. They must match
 	// in order for the client to be considered found, otherwise the client mentioned
 	// no longer exists.
-	if client.Server.Name != id.Server {
+	if found && client.Server.Name != id.Server {
 		client = nil
 		found = false
 	}
@@ -396,6 +678,12 @@ func validateConfig(config Config) {
 type newLink struct {
 	link   *Link
 	logger io.Writer
+
+	// TLS connection underlying the link, if any.
+	conn *tls.Conn
+
+	// When the link was begun, for the handshake timeout.
+	started time.Time
 }
 
 type syncRecord struct {
@@ -1,10 +1,14 @@
 package lib
 
 import (
+	"encoding/gob"
+	"fmt"
+	"io"
 	"log"
+	"strings"
 	"sync"
 	"testing"
-	//"time"
+	"time"
 )
 
 func TestNodeLevelLink(t *testing.T) {
@@ -77,6 +81,272 @@ func TestNickCollisionDuringLink(t *testing.T) {
 	wg.Wait()
 }
 
+type nickChangeRecorder struct {
+	ProxyEventHandler
+	changes chan string
+}
+
+func (ncr *nickChangeRecorder) OnNickChange(client *Client, oldNick string) {
+	ncr.changes <- oldNick + " -> " + client.Nick
+}
+
+func TestNickChange(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+
+	// hub.c doesn't speak CAP_NICK, and sees nick changes as a quit and
+	// rejoin.
+	hubC := tn.NewServer("hub.c")
+	hubC.node.config.Capabilities = []string{CAP_KEEPALIVE}
+	hubB.Link(hubC)
+
+	recorder := &nickChangeRecorder{changes: make(chan string, 10)}
+	hubB.node.Do(func() {
+		hubB.node.Handler = recorder
+	})
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubC.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+
+	if err := alpha.ChangeNick("gamma"); err != nil {
+		t.Fatalf("Failed to change nick: %v", err)
+	}
+	tn.ExpectAll(alpha.Exists())
+	tn.ExpectAll(tn.NickInUse("alpha").Not())
+	tn.ExpectAll(test.Member(alpha).IsOwner())
+
+	if _, ok := alpha.ChangeNick("Beta").(NameInUseError); !ok {
+		t.Errorf("Expected NameInUseError")
+	}
+
+	if err := beta.ChangeNick("delta"); err != nil {
+		t.Fatalf("Failed to change nick: %v", err)
+	}
+	tn.ExpectAll(beta.Exists())
+	tn.ExpectAll(tn.NickInUse("beta").Not())
+	tn.ExpectAll(test.Member(beta).Exists())
+
+	// Only the change hub.b was told about as such is reported.
+	select {
+	case change := <-recorder.changes:
+		if change != "alpha -> gamma" {
+			t.Errorf("Expected alpha -> gamma, got %s", change)
+		}
+	default:
+		t.Errorf("Expected a nick change on hub.b")
+	}
+	if len(recorder.changes) != 0 {
+		t.Errorf("Expected a single nick change on hub.b")
+	}
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// Change a nick on one server while another attaches a client with the same
+// nick, before either hears of the other.
+func changeNickRacingAttach(renamed *testClient, attached *testServer, nick string) *testClient {
+	tc := &testClient{
+		host: attached,
+		client: &Client{
+			Subnet: attached.node.DefaultSubnet,
+			Nick:   nick,
+			Ident:  nick,
+			Host:   fmt.Sprintf("host.%s", nick),
+			Gecos:  nick,
+			Ts:     time.Unix(attached.ts, 0),
+			Member: make(map[*Channel]*Membership),
+		},
+	}
+	start := make(chan struct{})
+	errs := make(chan error, 2)
+	renamed.host.node.Do(func() {
+		<-start
+		errs <- renamed.host.node.ChangeNick(renamed.client, nick)
+	})
+	attached.node.Do(func() {
+		<-start
+		errs <- attached.node.AttachClient(tc.client)
+	})
+	close(start)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			attached.net.t.Fatalf("Expected both sides to accept %s: %v", nick, err)
+		}
+	}
+	attached.net.Sync()
+	return tc
+}
+
+func TestNickChangeCollision(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+	hubB.NewLink("hub.c")
+
+	// The client attached on hub.b is older, so the renamed one dies.
+	alpha := hubA.NewClient("alpha")
+	hubB.SetTS(1)
+	bob := changeNickRacingAttach(alpha, hubB, "bob")
+	tn.ExpectAll(bob.Exists())
+	tn.ExpectAll(alpha.Exists().Not())
+	tn.ExpectAll(tn.NickInUse("alpha").Not())
+
+	// The client attached on hub.b is younger, so it dies instead.
+	gamma := hubA.NewClient("gamma")
+	hubB.SetTS(time.Now().Add(time.Hour).Unix())
+	carol := changeNickRacingAttach(gamma, hubB, "carol")
+	tn.ExpectAll(gamma.Exists())
+	tn.ExpectAll(carol.Exists().Not())
+	tn.ExpectAll(tn.NickInUse("gamma").Not())
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+type clientMoveRecorder struct {
+	ProxyEventHandler
+	moves chan string
+}
+
+func (cmr *clientMoveRecorder) OnClientMove(client *Client, oldSubnet *Subnet, oldNick string) {
+	cmr.moves <- fmt.Sprintf("%s:%s -> %s:%s", oldSubnet.Name, oldNick, client.Subnet.Name, client.Nick)
+}
+
+func TestClientMove(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+
+	// hub.c doesn't speak CAP_MOVE, and sees moves as a quit and rejoin.
+	hubC := tn.NewServer("hub.c")
+	hubC.node.config.Capabilities = []string{CAP_KEEPALIVE}
+	hubB.Link(hubC)
+
+	hubA.CreateSubnet("dev", "Developers")
+	recorder := &clientMoveRecorder{moves: make(chan string, 10)}
+	hubB.node.Do(func() {
+		hubB.node.Handler = recorder
+	})
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubC.NewClient("beta")
+	test := tn.NewChannel("test")
+	alpha.Join(test)
+	beta.Join(test)
+
+	if err := alpha.Move("dev", "Alpha"); err != nil {
+		t.Fatalf("Failed to move client: %v", err)
+	}
+	tn.ExpectAll(alpha.Exists())
+	tn.ExpectAll(tn.NickInUse("alpha").Not())
+	tn.ExpectAll(test.Member(alpha).IsOwner())
+
+	if err := beta.Move("dev", "beta"); err != nil {
+		t.Fatalf("Failed to move client: %v", err)
+	}
+	tn.ExpectAll(beta.Exists())
+	tn.ExpectAll(tn.NickInUse("beta").Not())
+	tn.ExpectAll(test.Member(beta).Exists())
+
+	// The nick is taken in the target subnet.
+	gamma := hubA.NewClient("gamma")
+	if _, ok := gamma.Move("dev", "beta").(NameInUseError); !ok {
+		t.Errorf("Expected NameInUseError")
+	}
+	tn.ExpectAll(gamma.Exists())
+
+	// Only the move hub.b was told about as such is reported.
+	select {
+	case move := <-recorder.moves:
+		if move != "test:alpha -> dev:Alpha" {
+			t.Errorf("Expected test:alpha -> dev:Alpha, got %s", move)
+		}
+	default:
+		t.Errorf("Expected a move on hub.b")
+	}
+	if len(recorder.moves) != 0 {
+		t.Errorf("Expected a single move on hub.b")
+	}
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+type quitRecorder struct {
+	ProxyEventHandler
+	events chan string
+}
+
+func (qr *quitRecorder) OnClientQuit(client *Client, reason string, code SSKillReason) {
+	qr.events <- fmt.Sprintf("quit %s: %s (%d)", client.Nick, reason, code)
+}
+
+func (qr *quitRecorder) OnClientKilled(client *Client, by *Client, reason string, code SSKillReason) {
+	qr.events <- fmt.Sprintf("%s killed %s: %s (%d)", by.Nick, client.Nick, reason, code)
+}
+
+func TestKill(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+	hubC := hubB.NewLink("hub.c")
+
+	recorder := &quitRecorder{events: make(chan string, 10)}
+	hubC.node.Do(func() {
+		hubC.node.Handler = recorder
+	})
+	expect := func(events ...string) {
+		for _, expected := range events {
+			if event := <-recorder.events; event != expected {
+				t.Errorf("Expected event '%s', got '%s'", expected, event)
+			}
+		}
+	}
+
+	alpha := hubA.NewClient("alpha")
+	beta := hubB.NewClient("beta")
+	gamma := hubA.NewClient("gamma")
+	delta := hubC.NewClient("delta")
+
+	if _, ok := alpha.Kill(beta, "go away").(NoPrivilegesError); !ok {
+		t.Errorf("Expected NoPrivilegesError")
+	}
+	hubA.node.Do(func() {
+		alpha.client.IsOper = true
+	})
+
+	// A remote client is killed by its own server.
+	if err := alpha.Kill(beta, "go away"); err != nil {
+		t.Fatalf("Failed to kill: %v", err)
+	}
+	tn.ExpectAll(beta.Exists().Not())
+	expect("alpha killed beta: go away (4)", "quit beta: go away (4)")
+
+	if err := alpha.Kill(gamma, "you too"); err != nil {
+		t.Fatalf("Failed to kill: %v", err)
+	}
+	tn.ExpectAll(gamma.Exists().Not())
+	expect("alpha killed gamma: you too (4)", "quit gamma: you too (4)")
+
+	// A client on the recording server.
+	if err := alpha.Kill(delta, "bye"); err != nil {
+		t.Fatalf("Failed to kill: %v", err)
+	}
+	tn.ExpectAll(delta.Exists().Not())
+	expect("alpha killed delta: bye (4)", "quit delta: bye (4)")
+
+	alpha.Quit("done")
+	expect("quit alpha: done (0)")
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
 func TestNodeSplitBasic(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	tnA, hubA := newTestNetwork(t, "hub.a", wg)
@@ -183,3 +453,280 @@ func TestNodeSplitMoveServer(t *testing.T) {
 	tn2.Shutdown()
 	wg.Wait()
 }
+
+// Begin a link from a node to a raw peer, returning the peer's view of the
+// link. The peer's side must be drained by the caller.
+func rawLink(node *Node) (ServerProtocolReader, ServerProtocolWriter, io.Closer) {
+	abr, abw := io.Pipe()
+	bar, baw := io.Pipe()
+	node.Do(func() {
+		node.BeginLink(bar, abw, nil, fmt.Sprintf("raw(%s)", node.Me.Name))
+	})
+	return NewGobServerProtocolReader(abr), NewGobServerProtocolWriter(baw), baw
+}
+
+// Read messages from a raw peer until an SSError arrives.
+func expectLinkError(t *testing.T, r ServerProtocolReader) string {
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected an error message, got %v", err)
+		}
+		if errMsg, ok := msg.(*SSError); ok {
+			return errMsg.Reason
+		}
+	}
+}
+
+// Read messages from a raw peer until the link closes, failing on any
+// SSError.
+func expectLinkClosed(t *testing.T, r ServerProtocolReader) {
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			return
+		}
+		if errMsg, ok := msg.(*SSError); ok {
+			t.Fatalf("Expected the link to close, got an error message: %s", errMsg.Reason)
+		}
+	}
+}
+
+func TestHandshake_WrongNetwork(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	_, hubA := newTestNetwork(t, "hub.a", wg)
+
+	r, w, c := rawLink(hubA.node)
+	w.WriteMessage(SSHello{
+		Protocol:      PROTOCOL_VERSION,
+		Name:          "hub.b",
+		Description:   "Other network",
+		DefaultSubnet: "test",
+		NetName:       "OtherNet",
+	})
+	reason := expectLinkError(t, r)
+	if !strings.Contains(reason, "OtherNet") {
+		t.Errorf("Expected the network name in the reason, got '%s'", reason)
+	}
+	hubA.Expect((&testServer{name: "hub.b"}).Exists().Not())
+
+	c.Close()
+	hubA.node.Shutdown()
+	wg.Wait()
+}
+
+func TestHandshake_WrongDefaultSubnet(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	_, hubA := newTestNetwork(t, "hub.a", wg)
+
+	r, w, c := rawLink(hubA.node)
+	w.WriteMessage(SSHello{
+		Protocol:      PROTOCOL_VERSION,
+		Name:          "hub.b",
+		Description:   "Other subnet",
+		DefaultSubnet: "other",
+		NetName:       "TestNet",
+	})
+	reason := expectLinkError(t, r)
+	if !strings.Contains(reason, "other") {
+		t.Errorf("Expected the subnet name in the reason, got '%s'", reason)
+	}
+
+	c.Close()
+	hubA.node.Shutdown()
+	wg.Wait()
+}
+
+// A server too old for this node isn't sent an SSError, which it wouldn't
+// understand.
+func TestHandshake_OldProtocol(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	config := testConfig("hub.a")
+	config.MinProtocol = 2
+	node := NewNode(config, nil, wg)
+
+	r, w, c := rawLink(node)
+	w.WriteMessage(SSHello{
+		Protocol:      1,
+		Name:          "hub.b",
+		Description:   "Old server",
+		DefaultSubnet: "test",
+	})
+	expectLinkClosed(t, r)
+
+	c.Close()
+	node.Shutdown()
+	wg.Wait()
+}
+
+func TestHandshake_Timeout(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	config := testConfig("hub.a")
+	config.HandshakeTimeout = 20 * time.Millisecond
+	node := NewNode(config, nil, wg)
+
+	// Never say hello. Without one, the protocol of the remote server is
+	// unknown, so it isn't sent an SSError.
+	r, _, c := rawLink(node)
+	expectLinkClosed(t, r)
+
+	ch := make(chan int)
+	node.Do(func() {
+		ch <- len(node.NewLinks)
+	})
+	if pending := <-ch; pending != 0 {
+		t.Errorf("Expected no pending links, got %d", pending)
+	}
+
+	c.Close()
+	node.Shutdown()
+	wg.Wait()
+}
+
+type violationHandler struct {
+	ProxyEventHandler
+	violations chan error
+}
+
+func (vh *violationHandler) OnProtocolViolation(server *Server, err error) {
+	vh.violations <- err
+}
+
+// Link a raw peer named hub.b to a node, and read its burst.
+func rawLinkEstablished(t *testing.T, node *Node) (ServerProtocolReader, ServerProtocolWriter, io.Closer) {
+	r, w, c := rawLink(node)
+	w.WriteMessage(SSHello{
+		Protocol:      PROTOCOL_VERSION,
+		Name:          "hub.b",
+		Description:   "Raw peer",
+		DefaultSubnet: "test",
+		NetName:       "TestNet",
+	})
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected a burst, got %v", err)
+		}
+		if _, ok := msg.(*SSBurstComplete); ok {
+			return r, w, c
+		}
+	}
+}
+
+func TestProtocolViolation_DuplicateServer(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	handler := &violationHandler{violations: make(chan error, 1)}
+	node := NewNode(testConfig("hub.a"), handler, wg)
+
+	r, w, c := rawLinkEstablished(t, node)
+	go func() {
+		for {
+			if _, err := r.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	w.WriteMessage(SSServer{Name: "hub.a", Desc: "Impostor", Via: "hub.b"})
+
+	err := <-handler.violations
+	if _, ok := err.(ProtocolViolationError); !ok {
+		t.Errorf("Expected ProtocolViolationError, got %v", err)
+	}
+	if isLinkedTo(node, "hub.b") {
+		t.Error("Expected hub.b to be split")
+	}
+
+	c.Close()
+	node.Shutdown()
+	wg.Wait()
+}
+
+func TestProtocolViolation_MessageLoop(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	handler := &violationHandler{violations: make(chan error, 1)}
+	node := NewNode(testConfig("hub.a"), handler, wg)
+
+	r, w, c := rawLinkEstablished(t, node)
+	go func() {
+		for {
+			if _, err := r.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	// hub.b sends a message to one of its own clients through hub.a.
+	w.WriteMessage(SSClient{Subnet: "test", Server: "hub.b", Nick: "beta", Ts: time.Now()})
+	beta := SSClientId{Server: "hub.b", Subnet: "test", Nick: "beta"}
+	w.WriteMessage(SSPrivateMessage{From: beta, To: beta, Message: "hello"})
+
+	err := <-handler.violations
+	if _, ok := err.(ProtocolViolationError); !ok {
+		t.Errorf("Expected ProtocolViolationError, got %v", err)
+	}
+	if isLinkedTo(node, "hub.b") {
+		t.Error("Expected hub.b to be split")
+	}
+
+	c.Close()
+	node.Shutdown()
+	wg.Wait()
+}
+
+func TestProtocolViolation_UnknownListMode(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	handler := &violationHandler{violations: make(chan error, 1)}
+	node := NewNode(testConfig("hub.a"), handler, wg)
+
+	r, w, c := rawLinkEstablished(t, node)
+	go func() {
+		for {
+			if _, err := r.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	w.WriteMessage(SSChannel{
+		Subnet: "test",
+		Name:   "test",
+		Ts:     time.Now(),
+		Lists:  []SSListEntry{{List: LIST_INVITE_EXCEPT + 1, Mask: "*!*@*"}},
+	})
+
+	err := <-handler.violations
+	if _, ok := err.(ProtocolViolationError); !ok {
+		t.Errorf("Expected ProtocolViolationError, got %v", err)
+	}
+	if isLinkedTo(node, "hub.b") {
+		t.Error("Expected hub.b to be split")
+	}
+
+	c.Close()
+	node.Shutdown()
+	wg.Wait()
+}
+
+func TestProtocolViolation_UnknownMessageType(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	handler := &violationHandler{violations: make(chan error, 1)}
+	node := NewNode(testConfig("hub.a"), handler, wg)
+
+	abr, abw := io.Pipe()
+	bar, baw := io.Pipe()
+	go io.Copy(io.Discard, abr)
+	node.Do(func() {
+		node.BeginLink(bar, abw, nil, "raw(hub.a)")
+	})
+	enc := gob.NewEncoder(baw)
+	enc.Encode(SSMessageHeader{SS_MSG_TYPE_HELLO})
+	enc.Encode(SSHello{Protocol: PROTOCOL_VERSION, Name: "hub.b", Description: "Raw peer", DefaultSubnet: "test", NetName: "TestNet"})
+	enc.Encode(SSMessageHeader{0xffff})
+
+	err := <-handler.violations
+	if _, ok := err.(ProtocolViolationError); !ok {
+		t.Errorf("Expected ProtocolViolationError, got %v", err)
+	}
+
+	baw.Close()
+	node.Shutdown()
+	wg.Wait()
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io"
-	"log"
 	"strings"
 	"time"
 )
@@ -25,6 +24,17 @@ const (
 	SS_MSG_TYPE_MEMBERSHIP_END
 	SS_MSG_TYPE_PM
 	SS_MSG_TYPE_CM
+	SS_MSG_TYPE_PING
+	SS_MSG_TYPE_PONG
+	SS_MSG_TYPE_ERROR
+	SS_MSG_TYPE_TOPIC
+	SS_MSG_TYPE_NICK
+	SS_MSG_TYPE_MOVE
+	SS_MSG_TYPE_SUBNET
+	SS_MSG_TYPE_SUBNET_END
+	SS_MSG_TYPE_SUBNET_POLICY
+	SS_MSG_TYPE_INVITE
+	SS_MSG_TYPE_KICK
 )
 
 type SSKillReason uint8
@@ -34,6 +44,14 @@ const (
 	SS_KILL_REASON_COLLISION
 	SS_KILL_REASON_SENDQ
 	SS_KILL_REASON_RECVQ
+
+	// Killed by an operator.
+	SS_KILL_REASON_KILLED
+
+	// Never sent, only reported to the event handler: the client's server
+	// split, or its subnet was deleted.
+	SS_KILL_REASON_SPLIT
+	SS_KILL_REASON_SUBNET_DELETED
 )
 
 type ssMessageConstructor func() SSMessage
@@ -81,6 +99,39 @@ func init() {
 	constructorMap[SS_MSG_TYPE_CHANNEL_MODE] = func() SSMessage {
 		return &SSChannelMode{}
 	}
+	constructorMap[SS_MSG_TYPE_PING] = func() SSMessage {
+		return &SSPing{}
+	}
+	constructorMap[SS_MSG_TYPE_PONG] = func() SSMessage {
+		return &SSPong{}
+	}
+	constructorMap[SS_MSG_TYPE_ERROR] = func() SSMessage {
+		return &SSError{}
+	}
+	constructorMap[SS_MSG_TYPE_TOPIC] = func() SSMessage {
+		return &SSTopic{}
+	}
+	constructorMap[SS_MSG_TYPE_NICK] = func() SSMessage {
+		return &SSNick{}
+	}
+	constructorMap[SS_MSG_TYPE_MOVE] = func() SSMessage {
+		return &SSMove{}
+	}
+	constructorMap[SS_MSG_TYPE_SUBNET] = func() SSMessage {
+		return &SSSubnet{}
+	}
+	constructorMap[SS_MSG_TYPE_SUBNET_END] = func() SSMessage {
+		return &SSSubnetEnd{}
+	}
+	constructorMap[SS_MSG_TYPE_SUBNET_POLICY] = func() SSMessage {
+		return &SSSubnetPolicy{}
+	}
+	constructorMap[SS_MSG_TYPE_INVITE] = func() SSMessage {
+		return &SSInvite{}
+	}
+	constructorMap[SS_MSG_TYPE_KICK] = func() SSMessage {
+		return &SSKick{}
+	}
 }
 
 var GobServerProtocolFactory ServerProtocolFactory = &gobServerProtocolFactory{}
@@ -98,15 +149,27 @@ type SSMessageHeader struct {
 
 /// Introduction message sent by one server to another at the beginning of link.
 type SSHello struct {
+	// Highest protocol version spoken by the sender.
 	Protocol      uint32
 	LocalTimeMs   uint64
 	Name          string
 	Description   string
 	DefaultSubnet string
+
+	// Lowest protocol version the sender will accept. Zero for servers which
+	// predate version negotiation.
+	MinProtocol uint32
+
+	// Optional features supported by the sender.
+	Capabilities []string
+
+	// Name of the sender's network. Empty for servers which predate version
+	// negotiation.
+	NetName string
 }
 
 func (msg SSHello) String() string {
-	return fmt.Sprintf("hello(%d, %d, %s, %s, %s)", msg.Protocol, msg.LocalTimeMs, msg.Name, msg.Description, msg.DefaultSubnet)
+	return fmt.Sprintf("hello(%d-%d, %d, %s, %s, %s, %s, caps[%s])", msg.MinProtocol, msg.Protocol, msg.LocalTimeMs, msg.NetName, msg.Name, msg.Description, msg.DefaultSubnet, strings.Join(msg.Capabilities, ", "))
 }
 
 func (msg SSHello) messageType() uint32 {
@@ -198,9 +261,9 @@ type SSKill struct {
 
 func (msg SSKill) String() string {
 	if msg.Authority {
-		return fmt.Sprintf("kill(id(%v), server(%s), by(%v), reason(%s))", msg.Id, msg.Server, msg.By, msg.Server)
+		return fmt.Sprintf("kill(id(%v), server(%s), by(%v), reason(%s))", msg.Id, msg.Server, msg.By, msg.Reason)
 	} else {
-		return fmt.Sprintf("kill?(id(%v), server(%s), by(%v), reason(%s))", msg.Id, msg.Server, msg.By, msg.Server)
+		return fmt.Sprintf("kill?(id(%v), server(%s), by(%v), reason(%s))", msg.Id, msg.Server, msg.By, msg.Reason)
 	}
 }
 
@@ -226,12 +289,37 @@ type SSChannel struct {
 	Subnet  string
 	Ts      time.Time
 	Members []*SSMembership
+
+	// Channel modes. A Limit of zero means no limit, and an empty Key no key.
+	TopicProtected, NoExternalMessages, Moderated, Secret bool
+	InviteOnly                                            bool
+	Limit                                                 uint32
+	Key                                                   string
+
+	Topic   string
+	TopicTs time.Time
+	TopicBy string
+
+	Lists []SSListEntry
 }
 
 func (msg SSChannel) messageType() uint32 {
 	return SS_MSG_TYPE_CHANNEL
 }
 
+// The channel-level modes carried by the message.
+func (msg SSChannel) Modes() ChannelModes {
+	return ChannelModes{
+		TopicProtected:     msg.TopicProtected,
+		NoExternalMessages: msg.NoExternalMessages,
+		Moderated:          msg.Moderated,
+		Secret:             msg.Secret,
+		InviteOnly:         msg.InviteOnly,
+		Limit:              msg.Limit,
+		Key:                msg.Key,
+	}
+}
+
 func (msg SSChannel) String() string {
 	members := make([]string, len(msg.Members))
 	for idx, member := range msg.Members {
@@ -292,7 +380,7 @@ type SSChannelMessage struct {
 }
 
 func (msg SSChannelMessage) messageType() uint32 {
-	return SS_MSG_TYPE_CHANNEL
+	return SS_MSG_TYPE_CM
 }
 
 func (msg SSChannelMessage) String() string {
@@ -343,7 +431,9 @@ func (value SSModeDelta) ToModeDelta() ModeDelta {
 	case SS_MODE_REMOVED:
 		return MODE_REMOVED
 	default:
-		panic("Unknown SSModeDelta")
+		// Values from the wire can't be trusted. Treat anything unknown as
+		// no change.
+		return MODE_UNCHANGED
 	}
 }
 
@@ -380,34 +470,63 @@ func (delta SSMemberModeDelta) ToMemberModeDelta(n *Node) (MemberModeDelta, bool
 
 type SSChannelModeDelta struct {
 	TopicProtected, NoExternalMessages, Moderated, Secret SSModeDelta
-	Limit, Key                                            SSModeDelta
+	InviteOnly, Limit, Key                                SSModeDelta
 	LimitValue                                            uint32
 	KeyValue                                              string
+	Lists                                                 []SSListModeDelta
+}
+
+// An entry of a channel's ban, exception or invite exception list.
+type SSListEntry struct {
+	List  ListMode
+	Mask  string
+	SetBy string
+	SetTs time.Time
+}
+
+type SSListModeDelta struct {
+	List   ListMode
+	Change SSModeDelta
+	Mask   string
+	SetBy  string
+	SetTs  time.Time
 }
 
 func ChannelModeDeltaToSSChannelModeDelta(value ChannelModeDelta) SSChannelModeDelta {
+	var ssLists []SSListModeDelta
+	for _, delta := range value.Lists {
+		ssLists = append(ssLists, SSListModeDelta{delta.List, SSModeDeltaFromModeDelta(delta.Change), delta.Mask, delta.SetBy, delta.SetTs})
+	}
 	return SSChannelModeDelta{
 		Key:                SSModeDeltaFromModeDelta(value.Key),
 		Limit:              SSModeDeltaFromModeDelta(value.Limit),
 		Moderated:          SSModeDeltaFromModeDelta(value.Moderated),
 		NoExternalMessages: SSModeDeltaFromModeDelta(value.NoExternalMessages),
 		Secret:             SSModeDeltaFromModeDelta(value.Secret),
+		InviteOnly:         SSModeDeltaFromModeDelta(value.InviteOnly),
 		TopicProtected:     SSModeDeltaFromModeDelta(value.TopicProtected),
 		KeyValue:           value.KeyValue,
 		LimitValue:         value.LimitValue,
+		Lists:              ssLists,
 	}
 }
 
 func (delta SSChannelModeDelta) ToChannelModeDelta() ChannelModeDelta {
+	var lists []ListModeDelta
+	for _, list := range delta.Lists {
+		lists = append(lists, ListModeDelta{list.List, list.Change.ToModeDelta(), list.Mask, list.SetBy, list.SetTs})
+	}
 	return ChannelModeDelta{
 		Key:                delta.Key.ToModeDelta(),
 		Limit:              delta.Limit.ToModeDelta(),
 		Moderated:          delta.Moderated.ToModeDelta(),
 		NoExternalMessages: delta.NoExternalMessages.ToModeDelta(),
 		Secret:             delta.Secret.ToModeDelta(),
+		InviteOnly:         delta.InviteOnly.ToModeDelta(),
 		TopicProtected:     delta.TopicProtected.ToModeDelta(),
 		KeyValue:           delta.KeyValue,
 		LimitValue:         delta.LimitValue,
+		Lists:              lists,
 	}
 }
 
@@ -426,6 +545,189 @@ func (msg SSChannelMode) String() string {
 	return fmt.Sprintf("mode(%s, %s)", msg.Channel, msg.From)
 }
 
+// Keepalive sent periodically over each link. Never propagated beyond the
+// directly connected server, which must answer with an SSPong carrying the
+// same cookie.
+type SSPing struct {
+	Cookie uint64
+}
+
+func (msg SSPing) messageType() uint32 {
+	return SS_MSG_TYPE_PING
+}
+
+func (msg SSPing) String() string {
+	return fmt.Sprintf("ping(%d)", msg.Cookie)
+}
+
+type SSPong struct {
+	Cookie uint64
+}
+
+func (msg SSPong) messageType() uint32 {
+	return SS_MSG_TYPE_PONG
+}
+
+func (msg SSPong) String() string {
+	return fmt.Sprintf("pong(%d)", msg.Cookie)
+}
+
+// Sent by a server just before it closes a link, explaining why.
+type SSError struct {
+	Reason string
+}
+
+func (msg SSError) messageType() uint32 {
+	return SS_MSG_TYPE_ERROR
+}
+
+func (msg SSError) String() string {
+	return fmt.Sprintf("error(%s)", msg.Reason)
+}
+
+// Change of a channel's topic. Only sent to servers which agreed to
+// CAP_TOPIC; others learn topics from channel bursts.
+type SSTopic struct {
+	From    SSClientId
+	Channel SSChannelId
+	Topic   string
+	Ts      time.Time
+	By      string
+}
+
+func (msg SSTopic) messageType() uint32 {
+	return SS_MSG_TYPE_TOPIC
+}
+
+func (msg SSTopic) String() string {
+	return fmt.Sprintf("topic(%s, %s, %s, ts(%v))", msg.Channel, msg.By, msg.Topic, msg.Ts)
+}
+
+// Change of a client's nick. Id is the client's id under its old nick, and Ts
+// its new timestamp. Only sent to servers which agreed to CAP_NICK; others see
+// the client quit and come back under its new nick.
+type SSNick struct {
+	Id   SSClientId
+	Nick string
+	Ts   time.Time
+}
+
+func (msg SSNick) messageType() uint32 {
+	return SS_MSG_TYPE_NICK
+}
+
+func (msg SSNick) String() string {
+	return fmt.Sprintf("nick(%s, %s, ts(%v))", msg.Id, msg.Nick, msg.Ts)
+}
+
+// Move of a client into another subnet, under a new nick. Id is the client's
+// id before the move, and Ts its new timestamp. Only sent to servers which
+// agreed to CAP_MOVE; others see the client quit and come back in its new
+// subnet.
+type SSMove struct {
+	Id     SSClientId
+	Subnet string
+	Nick   string
+	Ts     time.Time
+}
+
+func (msg SSMove) messageType() uint32 {
+	return SS_MSG_TYPE_MOVE
+}
+
+func (msg SSMove) String() string {
+	return fmt.Sprintf("move(%s, %s:%s, ts(%v))", msg.Id, msg.Subnet, msg.Nick, msg.Ts)
+}
+
+// Introduction of a subnet. Every server with CAP_SUBNET must know a subnet
+// before any of its clients or channels. Like SSSubnetEnd and SSSubnetPolicy,
+// it is only sent to servers with CAP_SUBNET.
+type SSSubnet struct {
+	Name        string
+	DisplayName string
+	Owner       string
+	Ts          time.Time
+	ForeignJoin ForeignJoinPolicy
+	PolicyTs    time.Time
+}
+
+func (msg SSSubnet) messageType() uint32 {
+	return SS_MSG_TYPE_SUBNET
+}
+
+func (msg SSSubnet) String() string {
+	return fmt.Sprintf("subnet(%s, %s, %s, ts(%v), foreign(%d))", msg.Name, msg.DisplayName, msg.Owner, msg.Ts, msg.ForeignJoin)
+}
+
+// Deletion of a subnet, along with anything still in it.
+type SSSubnetEnd struct {
+	Name string
+
+	// Server the subnet was deleted on.
+	Server string
+}
+
+func (msg SSSubnetEnd) messageType() uint32 {
+	return SS_MSG_TYPE_SUBNET_END
+}
+
+func (msg SSSubnetEnd) String() string {
+	return fmt.Sprintf("subnetEnd(%s from %s)", msg.Name, msg.Server)
+}
+
+// Change of a subnet's policy for joins from other subnets.
+type SSSubnetPolicy struct {
+	Name        string
+	ForeignJoin ForeignJoinPolicy
+
+	// When the policy was set.
+	Ts time.Time
+}
+
+func (msg SSSubnetPolicy) messageType() uint32 {
+	return SS_MSG_TYPE_SUBNET_POLICY
+}
+
+func (msg SSSubnetPolicy) String() string {
+	return fmt.Sprintf("subnetPolicy(%s, foreign(%d), ts(%v))", msg.Name, msg.ForeignJoin, msg.Ts)
+}
+
+// Invite of a client to a channel, routed to the target's server like a
+// private message. Only sent to servers which agreed to CAP_INVITE.
+type SSInvite struct {
+	From    SSClientId
+	To      SSClientId
+	Channel SSChannelId
+}
+
+func (msg SSInvite) messageType() uint32 {
+	return SS_MSG_TYPE_INVITE
+}
+
+func (msg SSInvite) String() string {
+	return fmt.Sprintf("invite(%s -> %s, %s)", msg.From, msg.To, msg.Channel)
+}
+
+// Removal of a member from a channel by another. ChannelTs is the channel's
+// timestamp where the kick was made, and Ts when it was made. Only sent to
+// servers which agreed to CAP_KICK; others see the target part.
+type SSKick struct {
+	From      SSClientId
+	Channel   SSChannelId
+	Target    SSClientId
+	Reason    string
+	ChannelTs time.Time
+	Ts        time.Time
+}
+
+func (msg SSKick) messageType() uint32 {
+	return SS_MSG_TYPE_KICK
+}
+
+func (msg SSKick) String() string {
+	return fmt.Sprintf("kick(%s, %s -> %s, %s, chts(%v), ts(%v))", msg.Channel, msg.From, msg.Target, msg.Reason, msg.ChannelTs, msg.Ts)
+}
+
 // TODO Why does SSClientId have Server?
 type SSClientId struct {
 	Server string
@@ -475,7 +777,8 @@ func (spr *gobServerProtocolReader) ReadMessage() (msg SSMessage, err error) {
 	}
 	ctor, ok := constructorMap[header.Type]
 	if !ok {
-		log.Fatalf("Unknown message type: %d", header.Type)
+		err = UnknownMessageTypeError{header.Type}
+		return
 	}
 
 	msg = ctor()
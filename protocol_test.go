@@ -12,14 +12,15 @@ func setupProtocolReaderWriter() (ServerProtocolReader, ServerProtocolWriter, io
 
 func TestEncodeDecodeHello(t *testing.T) {
 	r, w, _ := setupProtocolReaderWriter()
-	hello := SSHello{1, 123, "server.name", "server description", "test"}
+	hello := SSHello{Protocol: 1, LocalTimeMs: 123, Name: "server.name", Description: "server description", DefaultSubnet: "test"}
+	writeErr := make(chan error, 1)
 	go func() {
-		err := w.WriteMessage(hello)
-		if err != nil {
-			t.Fatal(err)
-		}
+		writeErr <- w.WriteMessage(hello)
 	}()
 	read, err := r.ReadMessage()
+	if err := <-writeErr; err != nil {
+		t.Fatal(err)
+	}
 	readHello, ok := read.(*SSHello)
 	if !ok {
 		t.Fatal("Didn't get an SSHello back")
@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// Accept server links from a listener until the node shuts down. Each
+// connection is linked on the node goroutine; connections from a TLS listener
+// complete their TLS handshake first, within the handshake timeout. When
+// Config.NetworkCA is set, the TLS listener's tls.Config must set ClientAuth to
+// RequireAndVerifyClientCert or RequireAnyClientCert, or every inbound link is
+// rejected for presenting no certificate. Safe to call from any goroutine.
+func (n *Node) Serve(listener net.Listener) {
+	n.serveMutex.Lock()
+	defer n.serveMutex.Unlock()
+	if n.stopped {
+		listener.Close()
+		return
+	}
+	n.listeners[listener] = struct{}{}
+	n.wg.Add(1)
+	go n.acceptLoop(listener)
+}
+
+func (n *Node) acceptLoop(listener net.Listener) {
+	defer n.wg.Done()
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if n.isStopped() {
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("[%s] no longer accepting links on %v: %v", n.config.ServerName, listener.Addr(), err)
+				n.serveMutex.Lock()
+				delete(n.listeners, listener)
+				n.serveMutex.Unlock()
+				return
+			}
+			// Anything else, such as running out of file descriptors
+			// (EMFILE, ENFILE) or a connection aborted before it was
+			// accepted (ECONNABORTED), may pass. Back off rather than
+			// spinning or giving up.
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay < time.Second {
+				delay *= 2
+			}
+			log.Printf("[%s] accept error on %v: %v; retrying in %v", n.config.ServerName, listener.Addr(), err, delay)
+			select {
+			case <-time.After(delay):
+			case <-n.done:
+				return
+			}
+			continue
+		}
+		delay = 0
+
+		if !n.trackAccepted(conn) {
+			conn.Close()
+			return
+		}
+		n.wg.Add(1)
+		go n.acceptLink(conn)
+	}
+}
+
+// Complete any TLS handshake off the node goroutine, then begin the link.
+func (n *Node) acceptLink(conn net.Conn) {
+	defer n.wg.Done()
+	defer n.untrackAccepted(conn)
+	name := "accept(" + conn.RemoteAddr().String() + ")"
+
+	tlsConn, isTLS := conn.(*tls.Conn)
+	if isTLS {
+		conn.SetDeadline(time.Now().Add(n.config.HandshakeTimeout))
+		err := tlsConn.Handshake()
+		if err != nil {
+			log.Printf("[%s] TLS handshake failed on link {%s}: %v", n.config.ServerName, name, err)
+			conn.Close()
+			return
+		}
+		// From here on, the node enforces the handshake timeout itself.
+		conn.SetDeadline(time.Time{})
+	}
+
+	started := n.Do(func() {
+		if isTLS {
+			n.BeginTLSLink(tlsConn, nil, name)
+		} else {
+			n.BeginLink(conn, conn, nil, name)
+		}
+	})
+	if !started {
+		conn.Close()
+	}
+}
+
+func (n *Node) isStopped() bool {
+	n.serveMutex.Lock()
+	defer n.serveMutex.Unlock()
+	return n.stopped
+}
+
+// Remember a connection until its link begins, so that it can be closed if
+// the node shuts down first. Returns false if the node is already stopped.
+func (n *Node) trackAccepted(conn net.Conn) bool {
+	n.serveMutex.Lock()
+	defer n.serveMutex.Unlock()
+	if n.stopped {
+		return false
+	}
+	n.accepted[conn] = struct{}{}
+	return true
+}
+
+func (n *Node) untrackAccepted(conn net.Conn) {
+	n.serveMutex.Lock()
+	defer n.serveMutex.Unlock()
+	delete(n.accepted, conn)
+}
+
+// Close every listener, and every accepted connection whose link hasn't
+// begun yet. Returns false if the node was already stopped.
+func (n *Node) stopServing() bool {
+	n.serveMutex.Lock()
+	defer n.serveMutex.Unlock()
+	if n.stopped {
+		return false
+	}
+	n.stopped = true
+	for listener, _ := range n.listeners {
+		listener.Close()
+	}
+	for conn, _ := range n.accepted {
+		conn.Close()
+	}
+	n.listeners = nil
+	n.accepted = nil
+	return true
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func listenTest(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+// Wait for the node's WaitGroup, failing rather than hanging if something
+// never stops.
+func waitOrFail(t *testing.T, wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for nodes to stop")
+	}
+}
+
+func TestServe_TCP(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	a := NewNode(testConfig("hub.a"), nil, wg)
+	b := NewNode(testConfig("hub.b"), nil, wg)
+
+	listener := listenTest(t)
+	a.Serve(listener)
+	b.Do(func() {
+		b.AddPeer(Peer{Name: "hub.a", Addr: listener.Addr().String()})
+	})
+	waitForNode(t, a, "link from hub.b", func() bool {
+		_, found := a.Me.Links["hub.b"]
+		return found
+	})
+
+	a.Shutdown()
+	b.Shutdown()
+	waitOrFail(t, wg)
+}
+
+func TestServe_TLS(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ca := newTestCA(t)
+	a := newTLSTestNode("hub.a", ca, wg)
+	config := testConfig("hub.b")
+	config.NetworkCA = ca.Pool()
+	certB := ca.Issue("hub.b")
+	config.Dial = func(peer Peer) (net.Conn, error) {
+		return tls.Dial("tcp", peer.Addr, &tls.Config{
+			Certificates: []tls.Certificate{certB},
+			RootCAs:      ca.Pool(),
+			ServerName:   peer.Name,
+		})
+	}
+	b := NewNode(config, nil, wg)
+
+	listener := tls.NewListener(listenTest(t), &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue("hub.a")},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	a.Serve(listener)
+	b.Do(func() {
+		b.AddPeer(Peer{Name: "hub.a", Addr: listener.Addr().String()})
+	})
+	waitForNode(t, a, "link from hub.b", func() bool {
+		_, found := a.Me.Links["hub.b"]
+		return found
+	})
+
+	a.Shutdown()
+	b.Shutdown()
+	waitOrFail(t, wg)
+}
+
+// A connection which never starts its TLS handshake is closed once the
+// handshake timeout passes.
+func TestServe_TLSHandshakeTimeout(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ca := newTestCA(t)
+	config := testConfig("hub.a")
+	config.HandshakeTimeout = 50 * time.Millisecond
+	a := NewNode(config, nil, wg)
+
+	listener := tls.NewListener(listenTest(t), &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue("hub.a")},
+	})
+	a.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || (ok && ne.Timeout()) {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+	conn.Close()
+
+	a.Shutdown()
+	waitOrFail(t, wg)
+}
+
+func TestServe_Shutdown(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	a := NewNode(testConfig("hub.a"), nil, wg)
+
+	listener := listenTest(t)
+	a.Serve(listener)
+
+	// A connection accepted but still saying nothing must not keep the node
+	// alive.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForNode(t, a, "accepted link", func() bool {
+		return len(a.NewLinks) == 1
+	})
+
+	a.Shutdown()
+	a.Shutdown()
+	waitOrFail(t, wg)
+
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Errorf("Expected the listener to be closed")
+	}
+
+	// Serving after shutdown closes the listener straight away.
+	late := listenTest(t)
+	a.Serve(late)
+	if _, err := late.Accept(); err == nil {
+		t.Errorf("Expected the late listener to be closed")
+	}
+	if a.Do(func() {}) {
+		t.Errorf("Expected Do to fail after shutdown")
+	}
+}
+
+// A listener which fails with EMFILE a few times before working normally.
+type exhaustedListener struct {
+	net.Listener
+	mutex    sync.Mutex
+	failures int
+}
+
+func (l *exhaustedListener) Accept() (net.Conn, error) {
+	l.mutex.Lock()
+	if l.failures > 0 {
+		l.failures--
+		l.mutex.Unlock()
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	l.mutex.Unlock()
+	return l.Listener.Accept()
+}
+
+// Running out of file descriptors only delays accepting links.
+func TestServe_AcceptBackoff(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	a := NewNode(testConfig("hub.a"), nil, wg)
+	b := NewNode(testConfig("hub.b"), nil, wg)
+
+	listener := &exhaustedListener{Listener: listenTest(t), failures: 3}
+	a.Serve(listener)
+	b.Do(func() {
+		b.AddPeer(Peer{Name: "hub.a", Addr: listener.Addr().String()})
+	})
+	waitForNode(t, a, "link from hub.b", func() bool {
+		_, found := a.Me.Links["hub.b"]
+		return found
+	})
+
+	a.Shutdown()
+	b.Shutdown()
+	waitOrFail(t, wg)
+}
@@ -2,24 +2,37 @@ package lib
 
 import (
 	"log"
+	"time"
 )
 
 type Server struct {
 	Name string
 	Desc string
 
-	Link *Link
+	Link  *Link
 	Route *Server
-	Hub *Server
+	Hub   *Server
 
 	Links map[string]*Server
+
+	// Protocol version and optional features agreed with a local server.
+	Protocol     uint32
+	Capabilities map[string]bool
+
+	// Round-trip time of the last keepalive answered by a local server.
+	Lag time.Duration
+
+	// Outstanding keepalive, if any, and when the last one was sent.
+	pingSent   time.Time
+	pingCookie uint64
+	pingLast   time.Time
 }
 
 func NewRemoteServer(name, desc string, hub *Server) *Server {
 	s := &Server{
-		Name: name,
-		Desc: desc,
-		Hub: hub,
+		Name:  name,
+		Desc:  desc,
+		Hub:   hub,
 		Route: hub.Route,
 		Links: make(map[string]*Server),
 	}
@@ -29,28 +42,35 @@ func NewRemoteServer(name, desc string, hub *Server) *Server {
 
 func NewLocalServer(name, desc string, link *Link, hub *Server) *Server {
 	s := &Server{
-		Name: name,
-		Desc: desc,
-		Link: link,
+		Name:  name,
+		Desc:  desc,
+		Link:  link,
 		Links: make(map[string]*Server),
-		Hub: hub,
+		Hub:   hub,
 	}
 	s.Route = s
 	return s
 }
 
+// Whether a local server agreed to use an optional feature.
+func (s *Server) HasCapability(capability string) bool {
+	return s.Capabilities[capability]
+}
+
+// Send a message towards a server. If writing fails, the link it goes over is
+// split once the node has handled the current event.
 func (s *Server) Send(msg SSMessage) {
 	log.Printf("[%s -> %s]: %s", s.Route.Hub.Name, s.Route.Name, msg.String())
 	err := s.Route.Link.WriteMessage(msg)
 	if err != nil {
-		log.Fatalf("[%s -> %s] error: %v", s.Route.Hub.Name, s.Route.Name, err)
+		log.Printf("[%s -> %s] error: %v", s.Route.Hub.Name, s.Route.Name, err)
 	}
 }
 
 func (s *Server) Serialize() SSMessage {
-	return &SSServer {
+	return &SSServer{
 		Name: s.Name,
 		Desc: s.Desc,
-		Via: s.Hub.Name,
+		Via:  s.Hub.Name,
 	}
 }
@@ -1,16 +1,77 @@
 package lib
 
+import (
+	"fmt"
+	"time"
+)
+
+// Whether clients from other subnets may join a subnet's channels.
+type ForeignJoinPolicy uint8
+
+// Ordered from the most to the least permissive.
+const (
+	FOREIGN_JOIN_OPEN ForeignJoinPolicy = iota
+	FOREIGN_JOIN_INVITE
+	FOREIGN_JOIN_CLOSED
+)
+
 type Subnet struct {
 	Name string
 
-	Client map[string]*Client
+	// Shown to users in place of the name, if set.
+	DisplayName string
+
+	// Account of whoever the subnet was created for, if anyone.
+	Owner string
+
+	// Creation time. When the same subnet was created on both sides of a
+	// split, the older one's metadata wins.
+	Ts time.Time
+
+	ForeignJoin ForeignJoinPolicy
+
+	// When ForeignJoin was last changed.
+	PolicyTs time.Time
+
+	Client  map[string]*Client
 	Channel map[string]*Channel
 }
 
 func NewSubnet(name string) *Subnet {
-	return &Subnet {
-		Name: name,
-		Client: make(map[string]*Client),
+	return &Subnet{
+		Name:    name,
+		Client:  make(map[string]*Client),
 		Channel: make(map[string]*Channel),
 	}
 }
+
+func (s *Subnet) IsEmpty() bool {
+	return len(s.Client) == 0 && len(s.Channel) == 0
+}
+
+func (s *Subnet) Serialize() *SSSubnet {
+	return &SSSubnet{
+		Name:        s.Name,
+		DisplayName: s.DisplayName,
+		Owner:       s.Owner,
+		Ts:          s.Ts,
+		ForeignJoin: s.ForeignJoin,
+		PolicyTs:    s.PolicyTs,
+	}
+}
+
+// Take a foreign join policy set at ts, returning whether it changed. The
+// most recently set policy wins, and the stricter one breaks ties, so that
+// every server reaches the same result whichever order changes arrive in.
+func (s *Subnet) mergePolicy(policy ForeignJoinPolicy, ts time.Time) bool {
+	if ts.Before(s.PolicyTs) || (ts.Equal(s.PolicyTs) && policy <= s.ForeignJoin) {
+		return false
+	}
+	s.ForeignJoin = policy
+	s.PolicyTs = ts
+	return true
+}
+
+func (s *Subnet) DebugString() string {
+	return fmt.Sprintf("subnet(%s display(%s) owner(%s) ts(%v) foreign(%d))", s.Name, s.DisplayName, s.Owner, s.Ts, s.ForeignJoin)
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubnet_CreateDelete(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+
+	if err := hubA.CreateSubnet("dev", "Developers"); err != nil {
+		t.Fatalf("Failed to create subnet: %v", err)
+	}
+	tn.ExpectAll(tn.HasSubnet("dev", "Developers"))
+	if _, ok := hubB.CreateSubnet("dev", "Again").(SubnetExistsError); !ok {
+		t.Errorf("Expected SubnetExistsError")
+	}
+
+	// Subnets are part of the burst, ahead of their clients.
+	hubC := hubB.NewLink("hub.c")
+	tn.ExpectAll(tn.HasSubnet("dev", "Developers"))
+	alpha := hubC.NewClient("alpha")
+	alpha.Move("dev", "alpha")
+	hubC.NewLink("hub.d")
+	tn.ExpectAll(alpha.Exists())
+
+	if _, ok := hubA.DeleteSubnet("dev").(SubnetNotEmptyError); !ok {
+		t.Errorf("Expected SubnetNotEmptyError")
+	}
+	if _, ok := hubA.DeleteSubnet("test").(DefaultSubnetError); !ok {
+		t.Errorf("Expected DefaultSubnetError")
+	}
+
+	alpha.Move("test", "alpha")
+	if err := hubA.DeleteSubnet("dev"); err != nil {
+		t.Fatalf("Failed to delete subnet: %v", err)
+	}
+	tn.ExpectAll(tn.HasSubnet("dev", "Developers").Not())
+	tn.ExpectAll(alpha.Exists())
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// A subnet created on both sides of a split keeps the older side's metadata.
+func TestSubnet_Netjoin(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+	hubB.NewLink("hub.c")
+	tnB := tn.SplitFromRoot(hubB)
+
+	hubB.CreateSubnet("dev", "Older")
+	time.Sleep(time.Millisecond)
+	hubA.CreateSubnet("dev", "Younger")
+
+	hubB.Link(hubA)
+	tnB.Sync()
+	tnB.ExpectAll(tnB.HasSubnet("dev", "Older"))
+
+	tn.Shutdown()
+	tnB.Shutdown()
+	wg.Wait()
+}
+
+// Servers without CAP_SUBNET never hear of subnets or their policies, in
+// bursts or otherwise.
+func TestSubnet_WithoutCapability(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+	hubA.CreateSubnet("dev", "Developers")
+
+	hubC := tn.NewServer("hub.c")
+	hubC.node.config.Capabilities = []string{CAP_KEEPALIVE}
+	hubB.Link(hubC)
+	hubA.CreateSubnet("ops", "Operators")
+	hubA.SetForeignJoinPolicy("ops", FOREIGN_JOIN_CLOSED)
+	tn.Sync()
+
+	hubB.node.Do(func() {
+		if policy := hubB.node.Subnet["ops"].ForeignJoin; policy != FOREIGN_JOIN_CLOSED {
+			t.Errorf("Expected ops to be closed on hub.b, got %d", policy)
+		}
+	})
+	hubB.Expect(tn.HasSubnet("dev", "Developers"))
+	hubB.Expect(tn.HasSubnet("ops", "Operators"))
+	hubC.Expect(tn.HasSubnet("dev", "Developers").Not())
+	hubC.Expect(tn.HasSubnet("ops", "Operators").Not())
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+type subnetEndRecorder struct {
+	ProxyEventHandler
+	parts      chan string
+	violations chan error
+}
+
+// Handlers see the membership still in place, as with any other part.
+func (r *subnetEndRecorder) OnChannelPart(channel *Channel, client *Client, reason string) {
+	if _, found := channel.Member[client]; !found {
+		reason += " (no membership)"
+	}
+	r.parts <- client.Nick + " " + channel.Name + ": " + reason
+}
+
+func (r *subnetEndRecorder) OnProtocolViolation(server *Server, err error) {
+	r.violations <- err
+}
+
+// A deletion which crosses a join into one of the subnet's channels parts the
+// joining client. Deletions must come from the deleting server's direction.
+func TestSubnet_DeleteWhileJoining(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+	hubB.NewLink("hub.c")
+	hubA.CreateSubnet("dev", "Developers")
+	hubA.CreateSubnet("ops", "Operators")
+
+	beta := hubB.NewClient("beta")
+	dev := tn.NewChannelIn("dev", "dev")
+	beta.Join(dev)
+
+	recorder := &subnetEndRecorder{parts: make(chan string, 10), violations: make(chan error, 1)}
+	node := hubB.node
+	node.Do(func() {
+		node.Handler = recorder
+		node.handleSubnetEnd(&SSSubnetEnd{Name: "dev", Server: "hub.a"}, node.Network["hub.a"])
+	})
+	if part := <-recorder.parts; part != "beta dev: Subnet deleted" {
+		t.Errorf("Unexpected part: %s", part)
+	}
+	hubB.Expect(tn.HasSubnet("dev", "Developers").Not())
+	hubB.Expect(dev.Member(beta).Exists().Not())
+
+	// hub.c is behind hub.b, not hub.a.
+	node.Do(func() {
+		node.handleSubnetEnd(&SSSubnetEnd{Name: "ops", Server: "hub.c"}, node.Network["hub.a"])
+	})
+	select {
+	case <-recorder.violations:
+	case <-time.After(time.Second):
+		t.Errorf("Expected a protocol violation for a deletion from the wrong direction")
+	}
+	hubB.Expect(tn.HasSubnet("ops", "Operators"))
+
+	tn.Shutdown()
+	wg.Wait()
+}
+
+// The most recently set policy wins across a netjoin, and a change which
+// crossed a newer one is dropped.
+func TestSubnet_PolicyNetjoin(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubB := hubA.NewLink("hub.b")
+	hubA.CreateSubnet("dev", "Developers")
+	tnB := tn.SplitFromRoot(hubB)
+
+	hubB.SetForeignJoinPolicy("dev", FOREIGN_JOIN_CLOSED)
+	time.Sleep(time.Millisecond)
+	hubA.SetForeignJoinPolicy("dev", FOREIGN_JOIN_INVITE)
+
+	hubB.Link(hubA)
+	tnB.Sync()
+	expectPolicy := func(ts *testServer, expected ForeignJoinPolicy) {
+		ts.node.Do(func() {
+			if policy := ts.node.Subnet["dev"].ForeignJoin; policy != expected {
+				t.Errorf("%s: expected policy %d, got %d", ts.name, expected, policy)
+			}
+		})
+	}
+	expectPolicy(hubA, FOREIGN_JOIN_INVITE)
+	expectPolicy(hubB, FOREIGN_JOIN_INVITE)
+
+	node := hubB.node
+	node.Do(func() {
+		subnet := node.Subnet["dev"]
+		node.handleSubnetPolicy(&SSSubnetPolicy{"dev", FOREIGN_JOIN_OPEN, subnet.PolicyTs.Add(-time.Second)}, node.Network["hub.a"])
+	})
+	tnB.Sync()
+	expectPolicy(hubA, FOREIGN_JOIN_INVITE)
+	expectPolicy(hubB, FOREIGN_JOIN_INVITE)
+
+	tn.Shutdown()
+	tnB.Shutdown()
+	wg.Wait()
+}
+
+// The default subnet's policy reaches servers which link after it was set.
+func TestSubnet_DefaultPolicyBurst(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	tn, hubA := newTestNetwork(t, "hub.a", wg)
+	hubA.SetForeignJoinPolicy("test", FOREIGN_JOIN_INVITE)
+
+	hubB := hubA.NewLink("hub.b")
+	tn.Sync()
+	hubB.node.Do(func() {
+		if policy := hubB.node.DefaultSubnet.ForeignJoin; policy != FOREIGN_JOIN_INVITE {
+			t.Errorf("Expected the default subnet to be invite only on hub.b, got %d", policy)
+		}
+	})
+
+	tn.Shutdown()
+	wg.Wait()
+}
@@ -32,6 +32,9 @@ type testClient struct {
 
 type testChannel struct {
 	name string
+
+	// Empty for the default subnet.
+	subnet string
 }
 
 func newTestNetwork(t *testing.T, rootServerName string, wg *sync.WaitGroup) (*testNetwork, *testServer) {
@@ -44,22 +47,51 @@ func newTestNetwork(t *testing.T, rootServerName string, wg *sync.WaitGroup) (*t
 		wg:  wg,
 	}
 	tn.root.net = tn
-	tn.root.node = NewNode(Config{rootServerName, "Test Server", "TestNet", "test"}, nil, wg)
+	tn.root.node = NewNode(testConfig(rootServerName), nil, wg)
 	tn.all[tn.root.node.Me.Name] = tn.root
 	return tn, tn.root
 }
 
+func testConfig(name string) Config {
+	return Config{
+		ServerName:        name,
+		ServerDesc:        "Test Server",
+		NetName:           "TestNet",
+		DefaultSubnetName: "test",
+	}
+}
+
 func (tn *testNetwork) NewServer(name string) *testServer {
 	server := &testServer{
 		name: name,
 		net:  tn,
 	}
-	server.node = NewNode(Config{name, "Test Server", "TestNet", "test"}, nil, tn.wg)
+	server.node = NewNode(testConfig(name), nil, tn.wg)
 	return server
 }
 
 func (tn *testNetwork) NewChannel(name string) *testChannel {
-	return &testChannel{name}
+	return &testChannel{name: name}
+}
+
+func (tn *testNetwork) NewChannelIn(subnet, name string) *testChannel {
+	return &testChannel{name: name, subnet: subnet}
+}
+
+func (tch *testChannel) subnetOn(node *Node) *Subnet {
+	if tch.subnet == "" {
+		return node.DefaultSubnet
+	}
+	return node.Subnet[tch.subnet]
+}
+
+func (tch *testChannel) lookupOn(node *Node) (*Channel, bool) {
+	subnet := tch.subnetOn(node)
+	if subnet == nil {
+		return nil, false
+	}
+	channel, found := subnet.Channel[tch.name]
+	return channel, found
 }
 
 func (ts *testServer) NewLink(name string) *testServer {
@@ -104,7 +136,9 @@ func (ts *testServer) NewClient(nick string) *testClient {
 			Member: make(map[*Channel]*Membership),
 		},
 	}
-	ts.node.AttachClient(tc.client)
+	ts.node.Do(func() {
+		ts.node.AttachClient(tc.client)
+	})
 	ts.net.Sync()
 	return tc
 }
@@ -128,18 +162,29 @@ func (ts *testServer) Expect(matcher testMatcher) {
 }
 
 func (tc *testClient) Join(tch *testChannel) {
+	if err := tc.TryJoin(tch); err != nil {
+		tc.host.net.t.Fatalf("Failure to join channel: %v", err)
+	}
+}
+
+func (tc *testClient) TryJoin(tch *testChannel) error {
+	return tc.TryJoinWithKey(tch, "")
+}
+
+func (tc *testClient) TryJoinWithKey(tch *testChannel, key string) error {
+	ch := make(chan error)
 	tc.host.node.Do(func() {
-		_, err := tc.host.node.JoinOrCreateChannel(tc.client, tc.host.node.DefaultSubnet, tch.name)
-		if err != nil {
-			tc.host.net.t.Fatalf("Failure to join channel: %v", err)
-		}
+		_, err := tc.host.node.JoinOrCreateChannelWithKey(tc.client, tch.subnetOn(tc.host.node), tch.name, key)
+		ch <- err
 	})
+	err := <-ch
 	tc.host.net.Sync()
+	return err
 }
 
 func (tc *testClient) Part(tch *testChannel, reason string) {
 	tc.host.node.Do(func() {
-		channel, found := tc.host.node.DefaultSubnet.Channel[tch.name]
+		channel, found := tch.lookupOn(tc.host.node)
 		if !found {
 			tc.host.net.t.Fatalf("Can't find channel to leave: %s", tch.name)
 		}
@@ -158,7 +203,7 @@ func (tc *testClient) Quit(reason string) {
 func (tc *testClient) findOn(node *Node) (*Client, bool) {
 	ch := make(chan *Client)
 	node.Do(func() {
-		client, found := node.DefaultSubnet.Client[tc.client.Lnick]
+		client, found := tc.lookupOn(node)
 		if !found {
 			ch <- nil
 		} else {
@@ -169,13 +214,22 @@ func (tc *testClient) findOn(node *Node) (*Client, bool) {
 	client := <-ch
 	if client == nil {
 		return nil, false
-		tc.host.net.t.Fatalf("Failed to find client %s on server %s", tc.client.Nick, node.Me.Name)
 	}
 	return client, true
 }
 
+// Look a client up by its host's view of its subnet and nick.
+func (tc *testClient) lookupOn(node *Node) (*Client, bool) {
+	subnet, found := node.Subnet[tc.client.Subnet.Name]
+	if !found {
+		return nil, false
+	}
+	client, found := subnet.Client[tc.client.Lnick]
+	return client, found
+}
+
 func (tc *testClient) SetChannelMode(tch *testChannel, mode string, arg ...interface{}) {
-	channel, found := tc.host.node.DefaultSubnet.Channel[tch.name]
+	channel, found := tch.lookupOn(tc.host.node)
 	if !found {
 		tc.host.net.t.Fatalf("Channel %s not found", tch.name)
 	}
@@ -195,6 +249,35 @@ func (tc *testClient) SetChannelMode(tch *testChannel, mode string, arg ...inter
 			operation = MODE_ADDED
 		case '-':
 			operation = MODE_REMOVED
+		case 'm':
+			delta.Moderated = operation
+		case 'n':
+			delta.NoExternalMessages = operation
+		case 's':
+			delta.Secret = operation
+		case 't':
+			delta.TopicProtected = operation
+		case 'i':
+			delta.InviteOnly = operation
+		case 'k':
+			delta.Key = operation
+			if operation == MODE_ADDED {
+				delta.KeyValue = tc.modeArg(r, arg, &argIdx).(string)
+			}
+		case 'b', 'e', 'I':
+			list := LIST_BAN
+			if r == 'e' {
+				list = LIST_EXCEPT
+			} else if r == 'I' {
+				list = LIST_INVITE_EXCEPT
+			}
+			mask := tc.modeArg(r, arg, &argIdx).(string)
+			delta.Lists = append(delta.Lists, ListModeDelta{List: list, Change: operation, Mask: mask})
+		case 'l':
+			delta.Limit = operation
+			if operation == MODE_ADDED {
+				delta.LimitValue = uint32(tc.modeArg(r, arg, &argIdx).(int))
+			}
 		case 'q', 'a', 'o', 'h', 'v':
 			if len(arg) <= argIdx {
 				tc.host.net.t.Fatalf("Missing argument for mode '%v'", r)
@@ -235,6 +318,140 @@ func (tc *testClient) SetChannelMode(tch *testChannel, mode string, arg ...inter
 	tc.host.net.Sync()
 }
 
+func (tc *testClient) modeArg(r rune, arg []interface{}, argIdx *int) interface{} {
+	if len(arg) <= *argIdx {
+		tc.host.net.t.Fatalf("Missing argument for mode '%v'", r)
+	}
+	*argIdx++
+	return arg[*argIdx-1]
+}
+
+func (tc *testClient) Say(tch *testChannel, message string) error {
+	ch := make(chan error)
+	tc.host.node.Do(func() {
+		channel, found := tch.lookupOn(tc.host.node)
+		if !found {
+			tc.host.net.t.Fatalf("Channel %s not found", tch.name)
+		}
+		ch <- tc.host.node.ChannelMessage(tc.client, channel, message)
+	})
+	err := <-ch
+	tc.host.net.Sync()
+	return err
+}
+
+func (tc *testClient) SetTopic(tch *testChannel, topic string) error {
+	ch := make(chan error)
+	tc.host.node.Do(func() {
+		channel, found := tch.lookupOn(tc.host.node)
+		if !found {
+			tc.host.net.t.Fatalf("Channel %s not found", tch.name)
+		}
+		ch <- tc.host.node.SetTopic(tc.client, channel, topic)
+	})
+	err := <-ch
+	tc.host.net.Sync()
+	return err
+}
+
+func (tc *testClient) ChangeNick(nick string) error {
+	ch := make(chan error)
+	tc.host.node.Do(func() {
+		ch <- tc.host.node.ChangeNick(tc.client, nick)
+	})
+	err := <-ch
+	tc.host.net.Sync()
+	return err
+}
+
+func (tc *testClient) Invite(target *testClient, tch *testChannel) error {
+	ch := make(chan error)
+	tc.host.node.Do(func() {
+		channel, found := tch.lookupOn(tc.host.node)
+		if !found {
+			tc.host.net.t.Fatalf("Channel %s not found", tch.name)
+		}
+		to, found := target.lookupOn(tc.host.node)
+		if !found {
+			tc.host.net.t.Fatalf("Client %s not found", target.client.Nick)
+		}
+		ch <- tc.host.node.Invite(tc.client, to, channel)
+	})
+	err := <-ch
+	tc.host.net.Sync()
+	return err
+}
+
+func (tc *testClient) Kick(tch *testChannel, target *testClient, reason string) error {
+	ch := make(chan error)
+	tc.host.node.Do(func() {
+		channel, found := tch.lookupOn(tc.host.node)
+		if !found {
+			tc.host.net.t.Fatalf("Channel %s not found", tch.name)
+		}
+		to, found := target.lookupOn(tc.host.node)
+		if !found {
+			tc.host.net.t.Fatalf("Client %s not found", target.client.Nick)
+		}
+		ch <- tc.host.node.Kick(tc.client, channel, to, reason)
+	})
+	err := <-ch
+	tc.host.net.Sync()
+	return err
+}
+
+func (tc *testClient) Kill(target *testClient, reason string) error {
+	ch := make(chan error)
+	tc.host.node.Do(func() {
+		to, found := target.lookupOn(tc.host.node)
+		if !found {
+			tc.host.net.t.Fatalf("Client %s not found", target.client.Nick)
+		}
+		ch <- tc.host.node.Kill(tc.client, to, reason)
+	})
+	err := <-ch
+	tc.host.net.Sync()
+	return err
+}
+
+func (ts *testServer) CreateSubnet(name, displayName string) error {
+	ch := make(chan error)
+	ts.node.Do(func() {
+		_, err := ts.node.CreateSubnet(name, displayName, "")
+		ch <- err
+	})
+	err := <-ch
+	ts.net.Sync()
+	return err
+}
+
+func (ts *testServer) SetForeignJoinPolicy(name string, policy ForeignJoinPolicy) {
+	ts.node.Do(func() {
+		ts.node.SetForeignJoinPolicy(ts.node.Subnet[name], policy)
+	})
+	ts.net.Sync()
+}
+
+func (ts *testServer) DeleteSubnet(name string) error {
+	ch := make(chan error)
+	ts.node.Do(func() {
+		ch <- ts.node.DeleteSubnet(ts.node.Subnet[name])
+	})
+	err := <-ch
+	ts.net.Sync()
+	return err
+}
+
+func (tc *testClient) Move(subnet string, nick string) error {
+	ch := make(chan error)
+	tc.host.node.Do(func() {
+		ch <- tc.host.node.MoveClient(tc.client, tc.host.node.Subnet[subnet], nick)
+	})
+	err := <-ch
+	tc.host.net.Sync()
+	return err
+}
+
 func (tc *testClient) Exists() *clientExistsMatcher {
 	return &clientExistsMatcher{tc}
 }
@@ -334,7 +551,10 @@ func (tn *testNetwork) setupVersionIncrementMonitor() chan struct{} {
 	count := len(tn.all)
 	for _, server := range tn.all {
 		node := server.node
-		node.versionMon = make(chan int)
+		// Buffered, as the node may bump its version before the goroutine
+		// below is ready to receive. A second bump still finds the channel
+		// full and fails.
+		node.versionMon = make(chan int, 1)
 		tn.wg.Add(1)
 		go func(node *Node) {
 			defer tn.wg.Done()
@@ -376,7 +596,7 @@ func (ms *membershipSelector) Select(ts *testServer) *Membership {
 	if !found {
 		return nil
 	}
-	channel, found := ts.node.DefaultSubnet.Channel[ms.channel.name]
+	channel, found := ms.channel.lookupOn(ts.node)
 	if !found {
 		// Not finding the channel is considered not finding the member,
 		// since during splits some servers may not have the channel
@@ -538,7 +758,7 @@ type clientExistsMatcher struct {
 }
 
 func (cem *clientExistsMatcher) Apply(ts *testServer) bool {
-	client, found := ts.node.DefaultSubnet.Client[cem.client.client.Lnick]
+	client, found := cem.client.lookupOn(ts.node)
 	if !found {
 		return false
 	}
@@ -557,6 +777,90 @@ func (cem *clientExistsMatcher) String() string {
 	return fmt.Sprintf("exists(%s)", cem.client.client.Nick)
 }
 
+// Matches if a subnet exists, with the given display name.
+type subnetMatcher struct {
+	name        string
+	displayName string
+}
+
+func (tn *testNetwork) HasSubnet(name, displayName string) *subnetMatcher {
+	return &subnetMatcher{name, displayName}
+}
+
+func (sm *subnetMatcher) Apply(ts *testServer) bool {
+	found := make(chan bool)
+	ts.node.Do(func() {
+		subnet, ok := ts.node.Subnet[sm.name]
+		found <- ok && subnet.DisplayName == sm.displayName
+	})
+	return <-found
+}
+
+func (sm *subnetMatcher) Not() testMatcher {
+	return &notMatcher{sm}
+}
+
+func (sm *subnetMatcher) String() string {
+	return fmt.Sprintf("subnet(%s, %s)", sm.name, sm.displayName)
+}
+
+// Matches a channel whose list has an entry for the mask, set by the given
+// nick.
+func (tch *testChannel) HasListEntry(list ListMode, mask string, setBy string) *listEntryMatcher {
+	return &listEntryMatcher{tch, list, mask, setBy}
+}
+
+type listEntryMatcher struct {
+	channel *testChannel
+	list    ListMode
+	mask    string
+	setBy   string
+}
+
+func (lem *listEntryMatcher) Apply(ts *testServer) bool {
+	found := make(chan bool)
+	ts.node.Do(func() {
+		channel, ok := lem.channel.lookupOn(ts.node)
+		if !ok {
+			found <- false
+			return
+		}
+		entry, ok := channel.list(lem.list)[strings.ToLower(lem.mask)]
+		found <- ok && entry.SetBy == lem.setBy && !entry.SetTs.IsZero()
+	})
+	return <-found
+}
+
+func (lem *listEntryMatcher) Not() testMatcher {
+	return &notMatcher{lem}
+}
+
+func (lem *listEntryMatcher) String() string {
+	return fmt.Sprintf("listEntry(#%s, %c, %s, %s)", lem.channel.name, lem.list.Char(), lem.mask, lem.setBy)
+}
+
+// Matches if any client, on any server, has the given nick.
+type nickInUseMatcher struct {
+	nick string
+}
+
+func (tn *testNetwork) NickInUse(nick string) *nickInUseMatcher {
+	return &nickInUseMatcher{nick}
+}
+
+func (nm *nickInUseMatcher) Apply(ts *testServer) bool {
+	_, found := ts.node.DefaultSubnet.Client[strings.ToLower(nm.nick)]
+	return found
+}
+
+func (nm *nickInUseMatcher) Not() testMatcher {
+	return &notMatcher{nm}
+}
+
+func (nm *nickInUseMatcher) String() string {
+	return fmt.Sprintf("nickInUse(%s)", nm.nick)
+}
+
 type serverLinkMatcher struct {
 	target *testServer
 }
@@ -647,3 +951,82 @@ func (tns *testNetworkStructure) Not() testMatcher {
 func (tns *testNetworkStructure) String() string {
 	return fmt.Sprintf("testNetworkStructure()")
 }
+
+// Matches a channel whose simple modes, key and limit are exactly as given,
+// e.g. "nt" or "ntk(secret)l(10)".
+func (tch *testChannel) HasModes(modes string) *channelModeMatcher {
+	return &channelModeMatcher{tch, modes}
+}
+
+type channelModeMatcher struct {
+	channel *testChannel
+	modes   string
+}
+
+func testChannelModes(channel *Channel) string {
+	modes := ""
+	if channel.Mode.InviteOnly {
+		modes += "i"
+	}
+	if channel.Mode.Moderated {
+		modes += "m"
+	}
+	if channel.Mode.NoExternalMessages {
+		modes += "n"
+	}
+	if channel.Mode.Secret {
+		modes += "s"
+	}
+	if channel.Mode.TopicProtected {
+		modes += "t"
+	}
+	if channel.Mode.Key != "" {
+		modes += fmt.Sprintf("k(%s)", channel.Mode.Key)
+	}
+	if channel.Mode.Limit != 0 {
+		modes += fmt.Sprintf("l(%d)", channel.Mode.Limit)
+	}
+	return modes
+}
+
+func (cmm *channelModeMatcher) Apply(ts *testServer) bool {
+	channel, found := cmm.channel.lookupOn(ts.node)
+	if !found {
+		return false
+	}
+	return testChannelModes(channel) == cmm.modes
+}
+
+func (cmm *channelModeMatcher) Not() testMatcher {
+	return &notMatcher{cmm}
+}
+
+func (cmm *channelModeMatcher) String() string {
+	return fmt.Sprintf("modes(#%s, %s)", cmm.channel.name, cmm.modes)
+}
+
+// Matches a channel with the given topic.
+func (tch *testChannel) HasTopic(topic string) *channelTopicMatcher {
+	return &channelTopicMatcher{tch, topic}
+}
+
+type channelTopicMatcher struct {
+	channel *testChannel
+	topic   string
+}
+
+func (ctm *channelTopicMatcher) Apply(ts *testServer) bool {
+	channel, found := ctm.channel.lookupOn(ts.node)
+	if !found {
+		return false
+	}
+	return channel.Topic == ctm.topic
+}
+
+func (ctm *channelTopicMatcher) Not() testMatcher {
+	return &notMatcher{ctm}
+}
+
+func (ctm *channelTopicMatcher) String() string {
+	return fmt.Sprintf("topic(#%s, %s)", ctm.channel.name, ctm.topic)
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Verify that a new link may be established to the server which introduced
+// itself as name. Without a network CA configured, every link is accepted.
+// Otherwise the link must be made over TLS, and the remote certificate must
+// chain to the network CA and be issued to the same name.
+func (n *Node) verifyLink(nl newLink, name string) error {
+	if n.config.NetworkCA == nil {
+		return nil
+	}
+	if nl.conn == nil {
+		return LinkVerificationError{name, "link is not using TLS"}
+	}
+	return verifyPeerCertificate(nl.conn.ConnectionState(), n.config.NetworkCA, n.revocations, name)
+}
+
+func verifyPeerCertificate(state tls.ConnectionState, roots *x509.CertPool, revocations []*x509.RevocationList, name string) error {
+	if len(state.PeerCertificates) == 0 {
+		return LinkVerificationError{name, "no certificate presented"}
+	}
+	cert := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, ic := range state.PeerCertificates[1:] {
+		intermediates.AddCert(ic)
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return LinkVerificationError{name, fmt.Sprintf("certificate not issued by network CA: %v", err)}
+	}
+
+	now := time.Now()
+	for _, chain := range chains {
+		if isRevoked(chain, revocations) {
+			return LinkVerificationError{name, fmt.Sprintf("certificate %s has been revoked", cert.SerialNumber)}
+		}
+		if crl := expiredRevocationList(chain, revocations, now); crl != nil {
+			return LinkVerificationError{name, fmt.Sprintf("revocation list from %q expired at %v", crl.Issuer.CommonName, crl.NextUpdate)}
+		}
+	}
+
+	if !certificateMatchesServer(cert, name) {
+		return LinkVerificationError{name, fmt.Sprintf("certificate issued to %q", cert.Subject.CommonName)}
+	}
+	return nil
+}
+
+// A server certificate matches a server name if either its subject or one of
+// its DNS names is the server name.
+func certificateMatchesServer(cert *x509.Certificate, name string) bool {
+	if cert.Subject.CommonName == name {
+		return true
+	}
+	return cert.VerifyHostname(name) == nil
+}
+
+// Whether any certificate in a verified chain has been revoked by its issuer.
+// Revocation lists are only trusted if they are signed by the issuer itself.
+func isRevoked(chain []*x509.Certificate, revocations []*x509.RevocationList) bool {
+	for idx := 0; idx+1 < len(chain); idx++ {
+		cert, issuer := chain[idx], chain[idx+1]
+		for _, crl := range revocations {
+			if crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// A revocation list from an issuer in a verified chain which is past its next
+// update, if any. Until a newer list is loaded, revocation by that issuer
+// can't be checked, so links are refused.
+func expiredRevocationList(chain []*x509.Certificate, revocations []*x509.RevocationList, now time.Time) *x509.RevocationList {
+	for _, issuer := range chain[1:] {
+		for _, crl := range revocations {
+			if crl.CheckSignatureFrom(issuer) == nil && !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+				return crl
+			}
+		}
+	}
+	return nil
+}
+
+// Load a DER encoded certificate revocation list issued by the network CA (or
+// one of its intermediates). Certificates it revokes are refused when servers
+// link, and once the list is past its next update, so is every certificate
+// from its issuer. A list replaces any list previously loaded from the same
+// issuer, unless it is older. Safe to call from any goroutine but the node's
+// own.
+func (n *Node) LoadRevocationList(der []byte) error {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return err
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		return ExpiredRevocationListError{}
+	}
+	result := make(chan error, 1)
+	if !n.Do(func() {
+		result <- n.storeRevocationList(crl)
+	}) {
+		return NodeStoppedError{}
+	}
+	return <-result
+}
+
+func (n *Node) storeRevocationList(crl *x509.RevocationList) error {
+	for idx, existing := range n.revocations {
+		if bytes.Equal(existing.RawIssuer, crl.RawIssuer) {
+			if existing.Number != nil && crl.Number != nil && crl.Number.Cmp(existing.Number) < 0 {
+				return StaleRevocationListError{}
+			}
+			n.revocations[idx] = crl
+			return nil
+		}
+	}
+	n.revocations = append(n.revocations, crl)
+	return nil
+}
+
+// Refuse a link which has not been registered as a local server. If the
+// remote server said hello with a protocol which has SSError, it is told why
+// before the link is closed. Older servers don't know the message, and are
+// only disconnected.
+func (n *Node) rejectLink(link *Link, hello *SSHello, err error) {
+	log.Printf("[%s] rejecting link {%s}: %v", n.Me.Name, link.name, err)
+	link.Silence = true
+	if hello != nil && hello.Protocol >= 2 {
+		link.WriteMessage(&SSError{err.Error()})
+	}
+	link.Shutdown()
+}
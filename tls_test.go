@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gossamer-irc/lib/ca"
+)
+
+// A network CA from the ca package, failing the test on any error.
+type testCA struct {
+	t *testing.T
+	*ca.Authority
+}
+
+func newTestCA(t *testing.T) *testCA {
+	authority, err := ca.New("TestNet", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{t, authority}
+}
+
+func (tca *testCA) Issue(name string) tls.Certificate {
+	cred, err := tca.IssueServer(name, time.Hour)
+	if err != nil {
+		tca.t.Fatal(err)
+	}
+	return cred.TLSCertificate()
+}
+
+// Create a revocation list revoking the given certificates, valid for the
+// given time.
+func (tca *testCA) Revoke(validity time.Duration, certs ...tls.Certificate) []byte {
+	serials := make([]*big.Int, 0, len(certs))
+	for _, cert := range certs {
+		serials = append(serials, cert.Leaf.SerialNumber)
+	}
+	der, err := tca.RevocationList(serials, big.NewInt(time.Now().UnixNano()), validity)
+	if err != nil {
+		tca.t.Fatal(err)
+	}
+	return der
+}
+
+func newTLSTestNode(name string, ca *testCA, wg *sync.WaitGroup) *Node {
+	config := testConfig(name)
+	config.NetworkCA = ca.Pool()
+	return NewNode(config, nil, wg)
+}
+
+// Link two nodes over TLS, each presenting the given certificate. Certificate
+// verification is left to the nodes themselves.
+func linkTLS(a, b *Node, certA, certB tls.Certificate) {
+	connA, connB := net.Pipe()
+	tlsA := tls.Client(connA, &tls.Config{
+		Certificates:       []tls.Certificate{certA},
+		InsecureSkipVerify: true,
+	})
+	tlsB := tls.Server(connB, &tls.Config{
+		Certificates: []tls.Certificate{certB},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	a.Do(func() {
+		a.BeginTLSLink(tlsA, nil, "tls(a -> b)")
+	})
+	b.Do(func() {
+		b.BeginTLSLink(tlsB, nil, "tls(b -> a)")
+	})
+}
+
+// Wait until a node has no links left in the handshake phase.
+func waitForNewLinks(t *testing.T, node *Node) {
+	for i := 0; i < 100; i++ {
+		ch := make(chan int)
+		node.Do(func() {
+			ch <- len(node.NewLinks)
+		})
+		if <-ch == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s: links never left the handshake phase", node.Me.Name)
+}
+
+func isLinkedTo(node *Node, name string) bool {
+	ch := make(chan bool)
+	node.Do(func() {
+		_, found := node.Me.Links[name]
+		ch <- found
+	})
+	return <-ch
+}
+
+func TestTLSLink_Verified(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ca := newTestCA(t)
+	a := newTLSTestNode("hub.a", ca, wg)
+	b := newTLSTestNode("hub.b", ca, wg)
+
+	linkTLS(a, b, ca.Issue("hub.a"), ca.Issue("hub.b"))
+	waitForNewLinks(t, a)
+	waitForNewLinks(t, b)
+
+	if !isLinkedTo(a, "hub.b") {
+		t.Errorf("hub.a: expected link to hub.b")
+	}
+	if !isLinkedTo(b, "hub.a") {
+		t.Errorf("hub.b: expected link to hub.a")
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
+
+func TestTLSLink_WrongName(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ca := newTestCA(t)
+	a := newTLSTestNode("hub.a", ca, wg)
+	b := newTLSTestNode("hub.b", ca, wg)
+
+	// hub.b presents a valid certificate, but for a different server.
+	linkTLS(a, b, ca.Issue("hub.a"), ca.Issue("hub.x"))
+	waitForNewLinks(t, a)
+
+	if isLinkedTo(a, "hub.b") {
+		t.Errorf("hub.a: linked to hub.b with a certificate for hub.x")
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
+
+func TestTLSLink_UnknownCA(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ca := newTestCA(t)
+	rogue := newTestCA(t)
+	a := newTLSTestNode("hub.a", ca, wg)
+	b := newTLSTestNode("hub.b", ca, wg)
+
+	linkTLS(a, b, ca.Issue("hub.a"), rogue.Issue("hub.b"))
+	waitForNewLinks(t, a)
+
+	if isLinkedTo(a, "hub.b") {
+		t.Errorf("hub.a: linked to hub.b with a certificate from another CA")
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
+
+func TestTLSLink_Revoked(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ca := newTestCA(t)
+	a := newTLSTestNode("hub.a", ca, wg)
+	b := newTLSTestNode("hub.b", ca, wg)
+
+	certB := ca.Issue("hub.b")
+	if err := a.LoadRevocationList(ca.Revoke(time.Hour, certB)); err != nil {
+		t.Fatalf("Failed to load revocation list: %v", err)
+	}
+
+	linkTLS(a, b, ca.Issue("hub.a"), certB)
+	waitForNewLinks(t, a)
+
+	if isLinkedTo(a, "hub.b") {
+		t.Errorf("hub.a: linked to hub.b with a revoked certificate")
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}
+
+func TestVerifyLink_RequiresTLS(t *testing.T) {
+	ca := newTestCA(t)
+	n := &Node{config: testConfig("hub.a")}
+	n.config.NetworkCA = ca.Pool()
+	err := n.verifyLink(newLink{}, "hub.b")
+	if _, ok := err.(LinkVerificationError); !ok {
+		t.Errorf("Expected LinkVerificationError, got %v", err)
+	}
+}
+
+func TestLoadRevocationList_Expired(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ca := newTestCA(t)
+	a := newTLSTestNode("hub.a", ca, wg)
+	b := newTLSTestNode("hub.b", ca, wg)
+
+	expired, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-2 * time.Hour),
+		NextUpdate: time.Now().Add(-time.Hour),
+	}, ca.Cert, ca.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.LoadRevocationList(expired).(ExpiredRevocationListError); !ok {
+		t.Errorf("Expected ExpiredRevocationListError")
+	}
+
+	// A list which expires after loading leaves revocation unchecked, and
+	// links are refused.
+	if err := a.LoadRevocationList(ca.Revoke(time.Hour, ca.Issue("hub.x"))); err != nil {
+		t.Fatalf("Failed to load revocation list: %v", err)
+	}
+	a.Do(func() {
+		a.revocations[0].NextUpdate = time.Now().Add(-time.Minute)
+	})
+	linkTLS(a, b, ca.Issue("hub.a"), ca.Issue("hub.b"))
+	waitForNewLinks(t, a)
+	if isLinkedTo(a, "hub.b") {
+		t.Errorf("hub.a: linked to hub.b with an expired revocation list")
+	}
+
+	a.Shutdown()
+	b.Shutdown()
+	wg.Wait()
+}